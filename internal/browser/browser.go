@@ -3,7 +3,12 @@ package browser
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/chromedp/chromedp"
 )
@@ -17,45 +22,223 @@ var (
 
 const BlankURL = "about:blank"
 
+const (
+	// DefaultGrowAfter is how long an acquirer waits for an idle page before the pool grows.
+	DefaultGrowAfter = 100 * time.Millisecond
+	// DefaultIdleTimeout is how long a page may stay idle before the pool shrinks.
+	DefaultIdleTimeout = time.Minute
+)
+
 // Page represents a single tab context managed by the pool.
 type Page struct {
 	Ctx    context.Context
 	Cancel context.CancelFunc
+
+	id       int
+	gen      *generation
+	inUse    bool
+	created  time.Time
+	lastUsed time.Time
+	renders  uint64
+	// profile is the index of the page's profile directory under
+	// PoolConfig.UserDataDir, or -1 without one.
+	profile int
+}
+
+// generation is one browser allocator. A rolling restart starts a new
+// generation and retires the previous one once all of its pages are gone.
+type generation struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pages   int
+	started time.Time
+}
+
+// PoolConfig controls how many pages the pool keeps and when it resizes.
+type PoolConfig struct {
+	// MinSize is the number of pages kept open even when idle.
+	MinSize int
+	// MaxSize is the upper bound the pool may grow to under load.
+	MaxSize int
+	// GrowAfter is how long an acquirer waits for an idle page before a new one is opened.
+	GrowAfter time.Duration
+	// Backlog, if set, reports how many tasks are queued for a page. While it
+	// is positive an acquirer that finds no idle page grows the pool right
+	// away instead of waiting GrowAfter.
+	Backlog func() int
+	// IdleTimeout is how long a page above MinSize may stay idle before it is closed.
+	IdleTimeout time.Duration
+	// RestartInterval triggers a rolling browser restart periodically. Zero disables it.
+	RestartInterval time.Duration
+	// MaxBrowserMemory triggers a rolling restart when a released page's browser
+	// uses more resident memory than this many bytes. Zero disables it.
+	MaxBrowserMemory uint64
+	// UserDataDir, if set, keeps browser profiles under this directory. Every
+	// page runs its own browser and Chrome locks a profile to one process, so
+	// each page gets a numbered subdirectory that is reused once its previous
+	// browser has exited.
+	UserDataDir string
 }
 
-// Pool manages a fixed number of chromedp page contexts.
+// Pool manages chromedp page contexts, scaling between a minimum and maximum size.
 type Pool struct {
-	size        int
-	allocCtx    context.Context
-	allocCancel context.CancelFunc
-	pages       chan *Page
-	mu          sync.Mutex
-	closed      bool
+	cfg           PoolConfig
+	size          int
+	waiting       int
+	parent        context.Context
+	allocatorOpts []chromedp.ExecAllocatorOption
+	current       *generation
+	draining      []*generation
+	all           map[*Page]struct{}
+	nextPageID    int
+	profiles      []bool
+	stats         counters
+	pages         chan *Page
+	done          chan struct{}
+	mu            sync.Mutex
+	closed        bool
 }
 
 // NewPool creates a pool with a shared browser allocator and N page contexts.
 func NewPool(parent context.Context, size int, opts ...chromedp.ExecAllocatorOption) (*Pool, error) {
-	if size <= 0 {
+	return NewElasticPool(parent, PoolConfig{MinSize: size, MaxSize: size}, opts...)
+}
+
+// NewElasticPool creates a pool that starts with cfg.MinSize pages and grows up
+// to cfg.MaxSize when acquirers have to wait or tasks are backlogged, shrinking
+// back after cfg.IdleTimeout.
+func NewElasticPool(parent context.Context, cfg PoolConfig, opts ...chromedp.ExecAllocatorOption) (*Pool, error) {
+	if cfg.MinSize <= 0 || cfg.MaxSize < cfg.MinSize {
 		return nil, ErrInvalidSize
 	}
+	if cfg.GrowAfter <= 0 {
+		cfg.GrowAfter = DefaultGrowAfter
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
 
 	allocatorOpts := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
 	allocatorOpts = append(allocatorOpts, opts...)
 
-	allocCtx, allocCancel := chromedp.NewExecAllocator(parent, allocatorOpts...)
-	pages := make(chan *Page, size)
+	p := &Pool{
+		cfg:           cfg,
+		parent:        parent,
+		allocatorOpts: allocatorOpts,
+		all:           make(map[*Page]struct{}),
+		pages:         make(chan *Page, cfg.MaxSize),
+		done:          make(chan struct{}),
+		stats:         newCounters(),
+	}
+	p.current = p.newGeneration()
+
+	for range cfg.MinSize {
+		p.pages <- p.newPage()
+	}
+	p.size = cfg.MinSize
+
+	if cfg.MaxSize > cfg.MinSize {
+		go p.shrinkLoop()
+	}
+	if cfg.RestartInterval > 0 {
+		go p.restartLoop()
+	}
+
+	return p, nil
+}
+
+func (p *Pool) newGeneration() *generation {
+	ctx, cancel := chromedp.NewExecAllocator(p.parent, p.allocatorOpts...)
+	return &generation{ctx: ctx, cancel: cancel, started: time.Now()}
+}
 
-	for range size {
-		ctx, cancel := chromedp.NewContext(allocCtx)
-		pages <- &Page{Ctx: ctx, Cancel: cancel}
+// newPage opens a page on the current generation; callers must hold p.mu or
+// otherwise own the pool exclusively.
+func (p *Pool) newPage() *Page {
+	allocCtx, profile := p.current.ctx, -1
+	cancelAlloc := func() {}
+	if p.cfg.UserDataDir != "" {
+		profile = p.takeProfile()
+		opts := append(slices.Clip(p.allocatorOpts), chromedp.UserDataDir(filepath.Join(p.cfg.UserDataDir, fmt.Sprintf("page-%d", profile))))
+		allocCtx, cancelAlloc = chromedp.NewExecAllocator(p.current.ctx, opts...)
 	}
 
-	return &Pool{
-		size:        size,
-		allocCtx:    allocCtx,
-		allocCancel: allocCancel,
-		pages:       pages,
-	}, nil
+	ctx, cancelPage := chromedp.NewContext(allocCtx)
+	// chromedp's cancel blocks forever when called twice on a context that
+	// allocated a browser, and a page may be canceled from several paths
+	cancel := sync.OnceFunc(func() {
+		cancelPage()
+		cancelAlloc()
+		if profile >= 0 {
+			p.releaseProfile(profile)
+		}
+	})
+	p.current.pages++
+	p.nextPageID++
+	now := time.Now()
+	page := &Page{Ctx: ctx, Cancel: cancel, id: p.nextPageID, gen: p.current, created: now, lastUsed: now, profile: profile}
+	p.all[page] = struct{}{}
+	return page
+}
+
+// takeProfile reserves the lowest free profile directory index; callers must
+// hold p.mu.
+func (p *Pool) takeProfile() int {
+	if i := slices.Index(p.profiles, false); i >= 0 {
+		p.profiles[i] = true
+		return i
+	}
+	p.profiles = append(p.profiles, true)
+	return len(p.profiles) - 1
+}
+
+// releaseProfile frees a profile directory once its browser has exited.
+func (p *Pool) releaseProfile(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profiles[i] = false
+}
+
+// discard closes page and shrinks the pool by one.
+func (p *Pool) discard(page *Page) {
+	p.mu.Lock()
+	p.size--
+	retired := p.detach(page)
+	p.mu.Unlock()
+
+	page.Cancel()
+	if retired != nil {
+		retired.cancel()
+	}
+}
+
+// replace closes page and opens a page on the current generation in its place,
+// so that retiring old pages never lowers the pool's capacity.
+func (p *Pool) replace(page *Page) *Page {
+	p.mu.Lock()
+	retired := p.detach(page)
+	replacement := p.newPage()
+	replacement.inUse = page.inUse
+	p.mu.Unlock()
+
+	page.Cancel()
+	if retired != nil {
+		retired.cancel()
+	}
+	return replacement
+}
+
+// detach removes page from its generation and returns the generation if it was
+// draining and has no pages left; callers must hold p.mu.
+func (p *Pool) detach(page *Page) *generation {
+	delete(p.all, page)
+	gen := page.gen
+	gen.pages--
+	if gen == p.current || gen.pages > 0 {
+		return nil
+	}
+	p.draining = slices.DeleteFunc(p.draining, func(g *generation) bool { return g == gen })
+	return gen
 }
 
 // Acquire waits for a free page or returns when ctx is done.
@@ -68,28 +251,248 @@ func (p *Pool) Acquire(ctx context.Context, initialURL string) (*Page, error) {
 		return nil, ErrPoolClosed
 	}
 
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case page := <-p.pages:
-		// navigate to initial URL
-		chromedp.Run(page.Ctx, chromedp.Navigate(initialURL))
-		return page, nil
+	start := time.Now()
+	page, err := p.take(ctx)
+	p.recordAcquire(page, time.Since(start), err)
+	if err != nil {
+		return nil, err
 	}
+
+	// navigate to initial URL
+	chromedp.Run(page.Ctx, chromedp.Navigate(initialURL))
+	return page, nil
 }
 
 func (p *Pool) AcquireBlank(ctx context.Context) (*Page, error) {
 	return p.Acquire(ctx, BlankURL)
 }
 
+// take hands out an idle page, growing the pool when the caller has waited
+// longer than GrowAfter, or at once when tasks are backlogged, and the pool is
+// below MaxSize.
+func (p *Pool) take(ctx context.Context) (*Page, error) {
+	p.mu.Lock()
+	p.waiting++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}()
+
+	// prefer an idle page over growing
+	select {
+	case page := <-p.pages:
+		if page, ok := p.checkout(page); ok {
+			return page, nil
+		}
+	default:
+	}
+
+	if p.backlogged() {
+		if page, ok := p.grow(); ok {
+			return page, nil
+		}
+	}
+
+	growTimer := time.NewTimer(p.cfg.GrowAfter)
+	defer growTimer.Stop()
+	grow := growTimer.C
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.done:
+			return nil, ErrPoolClosed
+		case page := <-p.pages:
+			if page, ok := p.checkout(page); ok {
+				return page, nil
+			}
+		case <-grow:
+			if page, ok := p.grow(); ok {
+				return page, nil
+			}
+			// at MaxSize: wait for a release instead of polling
+			grow = nil
+		}
+	}
+}
+
+// checkout marks page as in use, or replaces it when its context has already
+// been canceled (e.g. after a double return or a crashed tab) or its browser
+// is being restarted.
+func (p *Pool) checkout(page *Page) (*Page, bool) {
+	if page.Ctx.Err() != nil {
+		p.discard(page)
+		return p.grow()
+	}
+
+	p.mu.Lock()
+	stale := page.gen != p.current
+	page.inUse = true
+	p.mu.Unlock()
+
+	if stale {
+		return p.replace(page), true
+	}
+	return page, true
+}
+
+// backlogged reports whether tasks are queued behind the current acquirers.
+func (p *Pool) backlogged() bool {
+	return p.cfg.Backlog != nil && p.cfg.Backlog() > 0
+}
+
+// grow opens a new page if the pool is below MaxSize.
+func (p *Pool) grow() (*Page, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || p.size >= p.cfg.MaxSize {
+		return nil, false
+	}
+
+	page := p.newPage()
+	page.inUse = true
+	p.size++
+	return page, true
+}
+
+// shrinkLoop closes pages above MinSize that stayed idle for IdleTimeout.
+func (p *Pool) shrinkLoop() {
+	ticker := time.NewTicker(p.cfg.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.shrink()
+		}
+	}
+}
+
+func (p *Pool) shrink() {
+	now := time.Now()
+	if p.backlogged() {
+		return
+	}
+	for range len(p.pages) {
+		var page *Page
+		select {
+		case page = <-p.pages:
+		default:
+			return
+		}
+
+		p.mu.Lock()
+		idle := now.Sub(page.lastUsed) >= p.cfg.IdleTimeout
+		shrinkable := (idle || page.Ctx.Err() != nil) && p.size > p.cfg.MinSize && p.waiting == 0 && !p.closed
+		p.mu.Unlock()
+
+		if shrinkable {
+			p.discard(page)
+			continue
+		}
+
+		select {
+		case p.pages <- page:
+		default:
+			page.Cancel()
+		}
+	}
+}
+
+// restartLoop performs a rolling restart every RestartInterval.
+func (p *Pool) restartLoop() {
+	ticker := time.NewTicker(p.cfg.RestartInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.Restart()
+		}
+	}
+}
+
+// Restart performs a rolling browser restart: a new browser allocator takes
+// over all new acquisitions, idle pages are swapped for pages on the new
+// browser, and pages still in use are swapped as they are released. The old
+// browser is shut down once its last page is gone. The pool's capacity never
+// drops during the restart.
+func (p *Pool) Restart() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	old := p.current
+	p.current = p.newGeneration()
+	p.stats.restarts++
+	if old.pages == 0 {
+		p.mu.Unlock()
+		old.cancel()
+		return
+	}
+	p.draining = append(p.draining, old)
+	p.mu.Unlock()
+
+	log.Printf("browser pool restarting age=%s pages=%d", time.Since(old.started).Round(time.Second), old.pages)
+
+	for range len(p.pages) {
+		var page *Page
+		select {
+		case page = <-p.pages:
+		default:
+			return
+		}
+
+		p.mu.Lock()
+		stale := page.gen != p.current
+		p.mu.Unlock()
+		if stale {
+			page = p.replace(page)
+			// start the new browser before handing the page out
+			_ = chromedp.Run(page.Ctx)
+		}
+
+		select {
+		case p.pages <- page:
+		default:
+			p.discard(page)
+		}
+	}
+}
+
+// Restarting reports whether a previous browser generation is still draining.
+func (p *Pool) Restarting() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.draining) > 0
+}
+
 // Release returns a page to the pool.
 func (p *Pool) Release(page *Page) error {
+	return p.recordReturn(p.release(page))
+}
+
+func (p *Pool) release(page *Page) error {
 	if page == nil {
 		return ErrInvalidPage
 	}
 
 	p.mu.Lock()
 	closed := p.closed
+	inUse := page.inUse
+	stale := page.gen != p.current
+	restarting := len(p.draining) > 0
+	page.inUse = false
+	page.lastUsed = time.Now()
 	p.mu.Unlock()
 
 	if closed {
@@ -97,6 +500,20 @@ func (p *Pool) Release(page *Page) error {
 		return nil
 	}
 
+	if !inUse {
+		page.Cancel()
+		return ErrDoubleReturn
+	}
+
+	if stale {
+		page = p.replace(page)
+	} else if p.cfg.MaxBrowserMemory > 0 && !restarting {
+		if rss, err := browserMemory(page.Ctx); err == nil && rss > p.cfg.MaxBrowserMemory {
+			log.Printf("browser pool memory threshold exceeded rss=%d max=%d", rss, p.cfg.MaxBrowserMemory)
+			go p.Restart()
+		}
+	}
+
 	select {
 	case p.pages <- page:
 		return nil
@@ -106,6 +523,49 @@ func (p *Pool) Release(page *Page) error {
 	}
 }
 
+// Recycle closes a page that must not be reused, e.g. because it exceeded a
+// resource limit, and puts a fresh page into the pool in its place.
+func (p *Pool) Recycle(page *Page) error {
+	err := p.recordReturn(p.recycle(page))
+	if err == nil {
+		p.mu.Lock()
+		p.stats.recycled++
+		p.mu.Unlock()
+	}
+	return err
+}
+
+func (p *Pool) recycle(page *Page) error {
+	if page == nil {
+		return ErrInvalidPage
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	inUse := page.inUse
+	page.inUse = false
+	p.mu.Unlock()
+
+	if closed {
+		page.Cancel()
+		return nil
+	}
+
+	if !inUse {
+		page.Cancel()
+		return ErrDoubleReturn
+	}
+
+	replacement := p.replace(page)
+	select {
+	case p.pages <- replacement:
+		return nil
+	default:
+		p.discard(replacement)
+		return ErrDoubleReturn
+	}
+}
+
 // Close closes the pool and cancels all idle pages and the allocator.
 func (p *Pool) Close() {
 	p.mu.Lock()
@@ -114,6 +574,8 @@ func (p *Pool) Close() {
 		return
 	}
 	p.closed = true
+	close(p.done)
+	gens := append([]*generation{p.current}, p.draining...)
 	p.mu.Unlock()
 
 	for {
@@ -121,17 +583,31 @@ func (p *Pool) Close() {
 		case page := <-p.pages:
 			page.Cancel()
 		default:
-			p.allocCancel()
+			for _, gen := range gens {
+				gen.cancel()
+			}
 			return
 		}
 	}
 }
 
-// Size returns the configured pool size.
+// Size returns the current number of pages, idle or in use.
 func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.size
 }
 
+// MinSize returns the configured lower bound of the pool.
+func (p *Pool) MinSize() int {
+	return p.cfg.MinSize
+}
+
+// MaxSize returns the configured upper bound of the pool.
+func (p *Pool) MaxSize() int {
+	return p.cfg.MaxSize
+}
+
 // Available returns the number of idle pages in the pool.
 func (p *Pool) Available() int {
 	return len(p.pages)
@@ -3,6 +3,10 @@ package browser
 import (
 	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -76,6 +80,261 @@ func TestAcquireTimeout(t *testing.T) {
 	}
 }
 
+func TestElasticPoolGrowAndShrink(t *testing.T) {
+	t.Parallel()
+
+	pool, err := NewElasticPool(context.Background(), PoolConfig{
+		MinSize:     1,
+		MaxSize:     2,
+		GrowAfter:   time.Millisecond,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewElasticPool error: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	if got := pool.Size(); got != 1 {
+		t.Fatalf("expected initial size 1, got %d", got)
+	}
+
+	first, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire first error: %v", err)
+	}
+	second, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire second error: %v", err)
+	}
+	if got := pool.Size(); got != 2 {
+		t.Fatalf("expected pool to grow to 2, got %d", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.AcquireBlank(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded at max size, got %v", err)
+	}
+
+	if err := pool.Release(first); err != nil {
+		t.Fatalf("Release first error: %v", err)
+	}
+	if err := pool.Release(second); err != nil {
+		t.Fatalf("Release second error: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for pool.Size() > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := pool.Size(); got != 1 {
+		t.Fatalf("expected pool to shrink to 1, got %d", got)
+	}
+}
+
+func TestElasticPoolGrowsOnBacklog(t *testing.T) {
+	t.Parallel()
+
+	var backlog atomic.Int64
+	pool, err := NewElasticPool(context.Background(), PoolConfig{
+		MinSize:   1,
+		MaxSize:   3,
+		GrowAfter: time.Hour,
+		Backlog:   func() int { return int(backlog.Load()) },
+	})
+	if err != nil {
+		t.Fatalf("NewElasticPool error: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	first, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire first error: %v", err)
+	}
+
+	// without a backlog the acquirer waits GrowAfter for a release
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.AcquireBlank(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded without backlog, got %v", err)
+	}
+	if got := pool.Size(); got != 1 {
+		t.Fatalf("expected size 1 without backlog, got %d", got)
+	}
+
+	backlog.Store(5)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	second, err := pool.AcquireBlank(ctx)
+	if err != nil {
+		t.Fatalf("expected backlog to grow the pool, got %v", err)
+	}
+	if got := pool.Size(); got != 2 {
+		t.Fatalf("expected size 2 with backlog, got %d", got)
+	}
+
+	if err := pool.Release(first); err != nil {
+		t.Fatalf("Release first error: %v", err)
+	}
+	if err := pool.Release(second); err != nil {
+		t.Fatalf("Release second error: %v", err)
+	}
+}
+
+func TestUserDataDirPerPage(t *testing.T) {
+	t.Parallel()
+
+	pool, err := NewElasticPool(context.Background(), PoolConfig{MinSize: 2, MaxSize: 2, UserDataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewElasticPool error: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	first, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire first error: %v", err)
+	}
+	second, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire second error: %v", err)
+	}
+	if first.profile == second.profile {
+		t.Fatalf("expected pages to use separate profiles, both use %d", first.profile)
+	}
+
+	// the recycled page's profile is freed once its browser is gone
+	freed := first.profile
+	if err := pool.Recycle(first); err != nil {
+		t.Fatalf("Recycle error: %v", err)
+	}
+	if err := pool.Recycle(second); err != nil {
+		t.Fatalf("Recycle error: %v", err)
+	}
+	var profiles []int
+	for range 2 {
+		page, err := pool.AcquireBlank(context.Background())
+		if err != nil {
+			t.Fatalf("Acquire error: %v", err)
+		}
+		profiles = append(profiles, page.profile)
+		defer pool.Release(page)
+	}
+	if profiles[0] == profiles[1] || !slices.Contains(profiles, freed) {
+		t.Fatalf("expected distinct profiles reusing %d, got %v", freed, profiles)
+	}
+}
+
+func TestNewElasticPoolInvalidBounds(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewElasticPool(context.Background(), PoolConfig{MinSize: 3, MaxSize: 2})
+	if !errors.Is(err, ErrInvalidSize) {
+		t.Fatalf("expected ErrInvalidSize, got %v", err)
+	}
+}
+
+func TestRollingRestart(t *testing.T) {
+	t.Parallel()
+
+	pool, err := NewPool(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	busy, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	old := busy.gen
+
+	pool.Restart()
+
+	if !pool.Restarting() {
+		t.Fatal("expected old browser to drain while a page is in use")
+	}
+	if got := pool.Size(); got != 2 {
+		t.Fatalf("expected size 2 during restart, got %d", got)
+	}
+	if got := pool.Available(); got != 1 {
+		t.Fatalf("expected 1 available during restart, got %d", got)
+	}
+
+	idle, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	if idle.gen == old {
+		t.Fatal("expected idle page to be moved to the new browser")
+	}
+
+	if err := pool.Release(busy); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if err := pool.Release(idle); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+
+	if pool.Restarting() {
+		t.Fatal("expected old browser to be retired after its last page was released")
+	}
+	select {
+	case <-old.ctx.Done():
+	default:
+		t.Fatal("expected old browser allocator to be canceled")
+	}
+	if got := pool.Available(); got != 2 {
+		t.Fatalf("expected 2 available after restart, got %d", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	pool, err := NewPool(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	page, err := pool.AcquireBlank(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.AcquireBlank(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	stats := pool.Stats()
+	if stats.InUse != 1 || stats.Available != 0 || stats.Size != 1 {
+		t.Fatalf("unexpected usage: %+v", stats)
+	}
+	if stats.Acquisitions != 1 || stats.AcquireTimeouts != 1 {
+		t.Fatalf("unexpected acquisition counters: %+v", stats)
+	}
+	if stats.AcquireWait.Count != 1 || len(stats.AcquireWait.Buckets) != len(acquireWaitBuckets)+1 {
+		t.Fatalf("unexpected acquire wait histogram: %+v", stats.AcquireWait)
+	}
+
+	if err := pool.Release(page); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if err := pool.Release(page); !errors.Is(err, ErrDoubleReturn) {
+		t.Fatalf("expected ErrDoubleReturn, got %v", err)
+	}
+
+	stats = pool.Stats()
+	if stats.DoubleReturns != 1 {
+		t.Fatalf("expected 1 double return, got %d", stats.DoubleReturns)
+	}
+	if len(stats.Pages) != 1 || stats.Pages[0].Renders != 1 {
+		t.Fatalf("unexpected page stats: %+v", stats.Pages)
+	}
+}
+
 func TestCloseAndRelease(t *testing.T) {
 	t.Parallel()
 
@@ -158,14 +417,21 @@ func TestAccessExampleDotCom(t *testing.T) {
 		}
 	}()
 
+	// serve the page locally so the test does not depend on the network
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>Example Domain</title></head><body></body></html>"))
+	}))
+	defer site.Close()
+
 	ctx, cancel := context.WithTimeout(page.Ctx, 15*time.Second)
 	defer cancel()
-	t.Log("navigating to https://example.com")
+	t.Logf("navigating to %s", site.URL)
 
 	var title string
 	if err := chromedp.Run(
 		ctx,
-		chromedp.Navigate("https://example.com"),
+		chromedp.Navigate(site.URL),
 		chromedp.Title(&title),
 	); err != nil {
 		t.Fatalf("chromedp run error: %v", err)
@@ -0,0 +1,58 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// browserMemory returns the resident memory in bytes of the browser process
+// behind ctx and all of its descendants (renderers, GPU process, ...).
+func browserMemory(ctx context.Context) (uint64, error) {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Browser == nil || c.Browser.Process() == nil {
+		return 0, errors.New("browser process not available")
+	}
+	return processTreeRSS(c.Browser.Process().Pid)
+}
+
+func processTreeRSS(pid int) (uint64, error) {
+	statm, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected statm format for pid %d", pid)
+	}
+	residentPages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	total := residentPages * uint64(os.Getpagesize())
+
+	childFiles, _ := filepath.Glob(fmt.Sprintf("/proc/%d/task/*/children", pid))
+	for _, file := range childFiles {
+		children, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, raw := range strings.Fields(string(children)) {
+			child, err := strconv.Atoi(raw)
+			if err != nil {
+				continue
+			}
+			// children may exit while we walk the tree
+			if rss, err := processTreeRSS(child); err == nil {
+				total += rss
+			}
+		}
+	}
+	return total, nil
+}
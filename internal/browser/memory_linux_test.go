@@ -0,0 +1,18 @@
+package browser
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProcessTreeRSS(t *testing.T) {
+	t.Parallel()
+
+	rss, err := processTreeRSS(os.Getpid())
+	if err != nil {
+		t.Fatalf("processTreeRSS error: %v", err)
+	}
+	if rss == 0 {
+		t.Fatal("expected non-zero resident memory for the test process")
+	}
+}
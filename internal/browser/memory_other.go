@@ -0,0 +1,13 @@
+//go:build !linux
+
+package browser
+
+import (
+	"context"
+	"errors"
+)
+
+// browserMemory is only implemented on Linux, where it reads /proc.
+func browserMemory(ctx context.Context) (uint64, error) {
+	return 0, errors.New("browser memory is not supported on this platform")
+}
@@ -0,0 +1,158 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// acquireWaitBuckets are the upper bounds of the acquire wait histogram.
+var acquireWaitBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats is a point-in-time snapshot of the pool.
+type Stats struct {
+	Size            int         `json:"size"`
+	MinSize         int         `json:"min_size"`
+	MaxSize         int         `json:"max_size"`
+	Available       int         `json:"available"`
+	InUse           int         `json:"in_use"`
+	Waiting         int         `json:"waiting"`
+	Restarting      bool        `json:"restarting"`
+	Acquisitions    uint64      `json:"acquisitions"`
+	AcquireTimeouts uint64      `json:"acquire_timeouts"`
+	AcquireCanceled uint64      `json:"acquire_canceled"`
+	DoubleReturns   uint64      `json:"double_returns"`
+	Recycled        uint64      `json:"recycled"`
+	Restarts        uint64      `json:"restarts"`
+	AcquireWait     Histogram   `json:"acquire_wait"`
+	Pages           []PageStats `json:"pages"`
+}
+
+// Histogram counts observations per bucket. Each bucket counts the
+// observations above the previous bucket's bound and up to its own.
+type Histogram struct {
+	Buckets []Bucket      `json:"buckets"`
+	Count   uint64        `json:"count"`
+	Sum     time.Duration `json:"sum_ns"`
+}
+
+// Bucket is a single histogram bucket. The last bucket has no upper bound.
+type Bucket struct {
+	UpperBound string `json:"le"`
+	Count      uint64 `json:"count"`
+}
+
+// PageStats describes a single page currently owned by the pool.
+type PageStats struct {
+	ID      int           `json:"id"`
+	InUse   bool          `json:"in_use"`
+	Renders uint64        `json:"renders"`
+	Age     time.Duration `json:"age_ns"`
+	Idle    time.Duration `json:"idle_ns"`
+}
+
+type counters struct {
+	acquisitions    uint64
+	acquireTimeouts uint64
+	acquireCanceled uint64
+	doubleReturns   uint64
+	recycled        uint64
+	restarts        uint64
+	waitCounts      []uint64
+	waitSum         time.Duration
+}
+
+func newCounters() counters {
+	return counters{waitCounts: make([]uint64, len(acquireWaitBuckets)+1)}
+}
+
+func (p *Pool) recordAcquire(page *Page, wait time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		p.stats.acquireTimeouts++
+	case errors.Is(err, context.Canceled):
+		p.stats.acquireCanceled++
+	case err == nil:
+		p.stats.acquisitions++
+		page.renders++
+		bucket, _ := slices.BinarySearch(acquireWaitBuckets, wait)
+		p.stats.waitCounts[bucket]++
+		p.stats.waitSum += wait
+	}
+}
+
+func (p *Pool) recordReturn(err error) error {
+	if errors.Is(err, ErrDoubleReturn) {
+		p.mu.Lock()
+		p.stats.doubleReturns++
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// Stats returns a snapshot of the pool's size, usage and acquisition counters.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := Stats{
+		Size:            p.size,
+		MinSize:         p.cfg.MinSize,
+		MaxSize:         p.cfg.MaxSize,
+		Available:       len(p.pages),
+		Waiting:         p.waiting,
+		Restarting:      len(p.draining) > 0,
+		Acquisitions:    p.stats.acquisitions,
+		AcquireTimeouts: p.stats.acquireTimeouts,
+		AcquireCanceled: p.stats.acquireCanceled,
+		DoubleReturns:   p.stats.doubleReturns,
+		Recycled:        p.stats.recycled,
+		Restarts:        p.stats.restarts,
+		AcquireWait:     Histogram{Sum: p.stats.waitSum},
+	}
+
+	for i, count := range p.stats.waitCounts {
+		bound := "+Inf"
+		if i < len(acquireWaitBuckets) {
+			bound = acquireWaitBuckets[i].String()
+		}
+		stats.AcquireWait.Buckets = append(stats.AcquireWait.Buckets, Bucket{UpperBound: bound, Count: count})
+		stats.AcquireWait.Count += count
+	}
+
+	for page := range p.all {
+		pageStats := PageStats{
+			ID:      page.id,
+			InUse:   page.inUse,
+			Renders: page.renders,
+			Age:     now.Sub(page.created),
+		}
+		if page.inUse {
+			stats.InUse++
+		} else {
+			pageStats.Idle = now.Sub(page.lastUsed)
+		}
+		stats.Pages = append(stats.Pages, pageStats)
+	}
+	slices.SortFunc(stats.Pages, func(a, b PageStats) int { return a.ID - b.ID })
+
+	return stats
+}
@@ -1,18 +1,275 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
 	"slices"
+	"strings"
+	"time"
 
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/http/httpguts"
 	"gopkg.in/yaml.v3"
+
+	"github.com/IncorrectM/precrawl/internal/prerender"
 )
 
 type PreCrawlConfig struct {
-	BaseTargetURL      *string   `yaml:"base_target_url,omitempty"`
-	DefaultSelector    *string   `yaml:"default_selector,omitempty"`
-	DefaultWaitTimeout *string   `yaml:"default_wait_timeout,omitempty"`
-	Transformers       *[]string `yaml:"transformers,omitempty"`
-	WorkerCount        *int      `yaml:"worker_count,omitempty"`
+	BaseTargetURL       *string                  `yaml:"base_target_url,omitempty"`
+	DefaultSelector     *string                  `yaml:"default_selector,omitempty"`
+	DefaultWaitTimeout  *string                  `yaml:"default_wait_timeout,omitempty"`
+	Transformers        *[]string                `yaml:"transformers,omitempty"`
+	WorkerCount         *int                     `yaml:"worker_count,omitempty"`
+	PoolMinSize         *int                     `yaml:"pool_min_size,omitempty"`
+	PoolMaxSize         *int                     `yaml:"pool_max_size,omitempty"`
+	PoolGrowAfter       *string                  `yaml:"pool_grow_after,omitempty"`
+	PoolIdleTimeout     *string                  `yaml:"pool_idle_timeout,omitempty"`
+	PoolRestartInterval *string                  `yaml:"pool_restart_interval,omitempty"`
+	PoolMaxMemoryMB     *int                     `yaml:"pool_max_memory_mb,omitempty"`
+	Browser             *BrowserConfig           `yaml:"browser,omitempty"`
+	ResourceLimits      *LimitsConfig            `yaml:"resource_limits,omitempty"`
+	Devices             *map[string]DeviceConfig `yaml:"devices,omitempty"`
+	DefaultDevice       *string                  `yaml:"default_device,omitempty"`
+	DeviceAutoSelect    *bool                    `yaml:"device_auto_select,omitempty"`
+	DefaultLocale       *string                  `yaml:"default_locale,omitempty"`
+	DefaultTimezone     *string                  `yaml:"default_timezone,omitempty"`
+	DefaultGeolocation  *GeolocationConfig       `yaml:"default_geolocation,omitempty"`
+	Hosts               *map[string]HostConfig   `yaml:"hosts,omitempty"`
+	ExtraHeaderAllow    *[]string                `yaml:"extra_header_allowlist,omitempty"`
+	ExtraCookieAllow    *[]string                `yaml:"extra_cookie_allowlist,omitempty"`
+	ForwardHeaders      *[]string                `yaml:"forward_headers,omitempty"`
+	Scripts             *[]ScriptConfig          `yaml:"scripts,omitempty"`
+	Routes              *[]RouteConfig           `yaml:"routes,omitempty"`
+	RequestActions      *bool                    `yaml:"request_actions,omitempty"`
+	HARDir              *string                  `yaml:"har_dir,omitempty"`
+	HARThreshold        *string                  `yaml:"har_threshold,omitempty"`
+	HAREndpoint         *bool                    `yaml:"har_endpoint,omitempty"`
+	DiffEndpoint        *bool                    `yaml:"diff_endpoint,omitempty"`
+	ShadowDOM           *bool                    `yaml:"shadow_dom,omitempty"`
+	InlineFrames        *InlineFramesConfig      `yaml:"inline_frames,omitempty"`
+	Renderer            *string                  `yaml:"renderer,omitempty"`
+}
+
+// InlineFramesConfig turns on same-origin iframe inlining; zero values use
+// the prerender defaults.
+type InlineFramesConfig struct {
+	MaxDepth int `yaml:"max_depth,omitempty"`
+	MaxBytes int `yaml:"max_bytes,omitempty"`
+}
+
+// FrameInlining converts the config; it returns nil when inlining is not configured.
+func (f *InlineFramesConfig) FrameInlining() *prerender.FrameInlining {
+	if f == nil {
+		return nil
+	}
+	return &prerender.FrameInlining{MaxDepth: f.MaxDepth, MaxBytes: f.MaxBytes}
+}
+
+// RouteConfig holds render settings for request paths matching Paths.
+type RouteConfig struct {
+	Paths      []string          `yaml:"paths,omitempty"`
+	AutoScroll *AutoScrollConfig `yaml:"auto_scroll,omitempty"`
+	Actions    []ActionConfig    `yaml:"actions,omitempty"`
+	// Renderer overrides the default renderer: chrome, http or auto.
+	Renderer     string              `yaml:"renderer,omitempty"`
+	WaitResponse *WaitResponseConfig `yaml:"wait_response,omitempty"`
+}
+
+// WaitResponseConfig waits for a network response before capture; see
+// prerender.ResponseWait for the fields.
+type WaitResponseConfig struct {
+	URL     string  `yaml:"url"`
+	Status  int     `yaml:"status,omitempty"`
+	Timeout *string `yaml:"timeout,omitempty"`
+}
+
+// PrerenderResponseWait converts the route's response wait; it returns nil
+// when no response wait is configured.
+func (r RouteConfig) PrerenderResponseWait() (*prerender.ResponseWait, error) {
+	if r.WaitResponse == nil {
+		return nil, nil
+	}
+	wait := &prerender.ResponseWait{URL: r.WaitResponse.URL, Status: r.WaitResponse.Status}
+	if r.WaitResponse.Timeout != nil {
+		var err error
+		if wait.Timeout, err = parseDuration("wait_response.timeout", *r.WaitResponse.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return wait, nil
+}
+
+// AutoScrollConfig enables auto-scroll for a route; empty fields use the
+// prerender defaults.
+type AutoScrollConfig struct {
+	StepPx      int     `yaml:"step_px,omitempty"`
+	Delay       *string `yaml:"delay,omitempty"`
+	MaxSteps    int     `yaml:"max_steps,omitempty"`
+	MaxDuration *string `yaml:"max_duration,omitempty"`
+}
+
+// PrerenderAutoScroll converts the route's auto-scroll settings; it returns
+// nil when auto-scroll is not configured.
+func (r RouteConfig) PrerenderAutoScroll() (*prerender.AutoScroll, error) {
+	if r.AutoScroll == nil {
+		return nil, nil
+	}
+	scroll := &prerender.AutoScroll{StepPx: r.AutoScroll.StepPx, MaxSteps: r.AutoScroll.MaxSteps}
+	var err error
+	if r.AutoScroll.Delay != nil {
+		if scroll.Delay, err = parseDuration("auto_scroll.delay", *r.AutoScroll.Delay); err != nil {
+			return nil, err
+		}
+	}
+	if r.AutoScroll.MaxDuration != nil {
+		if scroll.MaxDuration, err = parseDuration("auto_scroll.max_duration", *r.AutoScroll.MaxDuration); err != nil {
+			return nil, err
+		}
+	}
+	return scroll, nil
+}
+
+// ActionConfig is an interaction step; see prerender.Action for the fields.
+type ActionConfig struct {
+	Type      prerender.ActionType `yaml:"type"`
+	Selector  string               `yaml:"selector,omitempty"`
+	Text      string               `yaml:"text,omitempty"`
+	Script    string               `yaml:"script,omitempty"`
+	Ms        int                  `yaml:"ms,omitempty"`
+	Y         int                  `yaml:"y,omitempty"`
+	TimeoutMs int                  `yaml:"timeout_ms,omitempty"`
+	Optional  bool                 `yaml:"optional,omitempty"`
+}
+
+// PrerenderActions converts the route's actions.
+func (r RouteConfig) PrerenderActions() []prerender.Action {
+	actions := make([]prerender.Action, 0, len(r.Actions))
+	for _, a := range r.Actions {
+		actions = append(actions, prerender.Action(a))
+	}
+	return actions
+}
+
+// ScriptConfig is a script injected before navigation, given inline or as a
+// file path, optionally limited to request paths matching path.Match patterns.
+type ScriptConfig struct {
+	Inline string   `yaml:"inline,omitempty"`
+	File   string   `yaml:"file,omitempty"`
+	Paths  []string `yaml:"paths,omitempty"`
+}
+
+// Source returns the script's JavaScript, reading File if set.
+func (s ScriptConfig) Source() (string, error) {
+	if s.File == "" {
+		return s.Inline, nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s ScriptConfig) validate() error {
+	if (s.Inline == "") == (s.File == "") {
+		return errors.New("exactly one of inline or file must be set")
+	}
+	if _, err := s.Source(); err != nil {
+		return err
+	}
+	return validatePatterns(s.Paths)
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("paths %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// HostConfig holds the headers and cookies sent when rendering a target host.
+// Headers only go to the target's origin, never to third-party hosts.
+type HostConfig struct {
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Cookies []CookieConfig    `yaml:"cookies,omitempty"`
+}
+
+// CookieConfig is a cookie set before navigation; an empty domain scopes it
+// to the target host.
+type CookieConfig struct {
+	Name     string `yaml:"name"`
+	Value    string `yaml:"value"`
+	Domain   string `yaml:"domain,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Secure   bool   `yaml:"secure,omitempty"`
+	HTTPOnly bool   `yaml:"http_only,omitempty"`
+}
+
+// Options converts the host config into render options.
+func (h HostConfig) Options() prerender.Options {
+	options := prerender.Options{Headers: h.Headers}
+	for _, c := range h.Cookies {
+		options.Cookies = append(options.Cookies, prerender.Cookie(c))
+	}
+	return options
+}
+
+// GeolocationConfig is an emulated position; accuracy is in meters.
+type GeolocationConfig struct {
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	Accuracy  float64 `yaml:"accuracy,omitempty"`
+}
+
+// Geolocation converts the config into a prerender.Geolocation.
+func (g *GeolocationConfig) Geolocation() *prerender.Geolocation {
+	if g == nil {
+		return nil
+	}
+	return &prerender.Geolocation{Latitude: g.Latitude, Longitude: g.Longitude, Accuracy: g.Accuracy}
+}
+
+// DeviceConfig is a named viewport and user agent profile.
+type DeviceConfig struct {
+	Width             int     `yaml:"width"`
+	Height            int     `yaml:"height"`
+	DeviceScaleFactor float64 `yaml:"device_scale_factor,omitempty"`
+	Mobile            bool    `yaml:"mobile,omitempty"`
+	Touch             bool    `yaml:"touch,omitempty"`
+	UserAgent         string  `yaml:"user_agent,omitempty"`
+}
+
+// LimitsConfig caps the resources a single page may use during a render.
+type LimitsConfig struct {
+	MaxJSHeapMB   *int64  `yaml:"max_js_heap_mb,omitempty"`
+	MaxDOMNodes   *int64  `yaml:"max_dom_nodes,omitempty"`
+	MaxCPUTime    *string `yaml:"max_cpu_time,omitempty"`
+	CheckInterval *string `yaml:"check_interval,omitempty"`
+}
+
+// BrowserConfig describes how the Chrome process backing the pool is launched.
+type BrowserConfig struct {
+	ExecPath    *string     `yaml:"exec_path,omitempty"`
+	UserAgent   *string     `yaml:"user_agent,omitempty"`
+	WindowSize  *WindowSize `yaml:"window_size,omitempty"`
+	ProxyServer *string     `yaml:"proxy_server,omitempty"`
+	Headless    *bool       `yaml:"headless,omitempty"`
+	DisableGPU  *bool       `yaml:"disable_gpu,omitempty"`
+	NoSandbox   *bool       `yaml:"no_sandbox,omitempty"`
+	UserDataDir *string     `yaml:"user_data_dir,omitempty"`
+	ExtraFlags  *[]string   `yaml:"extra_flags,omitempty"`
+}
+
+type WindowSize struct {
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
 }
 
 var posibleTransformerTypes = []string{
@@ -30,11 +287,311 @@ func LoadConfig(
 		return nil, err
 	}
 
-	for _, t := range *config.Transformers {
-		if !slices.Contains(posibleTransformerTypes, t) {
-			return nil, fmt.Errorf("invalid transformer type %s", t)
+	if config.Transformers != nil {
+		for _, t := range *config.Transformers {
+			if !slices.Contains(posibleTransformerTypes, t) {
+				return nil, fmt.Errorf("invalid transformer type %s", t)
+			}
+		}
+	}
+
+	if config.PoolMinSize != nil && *config.PoolMinSize <= 0 {
+		return nil, fmt.Errorf("pool_min_size must be positive, got %d", *config.PoolMinSize)
+	}
+	if config.PoolMaxSize != nil && *config.PoolMaxSize <= 0 {
+		return nil, fmt.Errorf("pool_max_size must be positive, got %d", *config.PoolMaxSize)
+	}
+	if config.PoolMinSize != nil && config.PoolMaxSize != nil && *config.PoolMaxSize < *config.PoolMinSize {
+		return nil, fmt.Errorf("pool_max_size (%d) must not be less than pool_min_size (%d)", *config.PoolMaxSize, *config.PoolMinSize)
+	}
+
+	if config.PoolMaxMemoryMB != nil && *config.PoolMaxMemoryMB < 0 {
+		return nil, fmt.Errorf("pool_max_memory_mb must not be negative, got %d", *config.PoolMaxMemoryMB)
+	}
+
+	if config.Browser != nil {
+		if err := config.Browser.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ResourceLimits != nil {
+		if _, err := config.ResourceLimits.Limits(); err != nil {
+			return nil, err
+		}
+	}
+
+	devices, err := config.DeviceProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if config.DefaultDevice != nil {
+		if _, ok := devices[strings.ToLower(*config.DefaultDevice)]; !ok {
+			return nil, fmt.Errorf("default_device: unknown device %q", *config.DefaultDevice)
+		}
+	}
+
+	if config.DefaultLocale != nil {
+		if err := prerender.ValidateLocale(*config.DefaultLocale); err != nil {
+			return nil, fmt.Errorf("default_locale: %w", err)
+		}
+	}
+	if config.DefaultTimezone != nil {
+		if err := prerender.ValidateTimezone(*config.DefaultTimezone); err != nil {
+			return nil, fmt.Errorf("default_timezone: %w", err)
+		}
+	}
+	if config.DefaultGeolocation != nil {
+		if err := config.DefaultGeolocation.Geolocation().Validate(); err != nil {
+			return nil, fmt.Errorf("default_geolocation: %w", err)
+		}
+	}
+
+	if config.Hosts != nil {
+		for host, h := range *config.Hosts {
+			if err := h.Options().Validate(); err != nil {
+				return nil, fmt.Errorf("hosts.%s: %w", host, err)
+			}
+		}
+	}
+
+	if config.Scripts != nil {
+		for i, script := range *config.Scripts {
+			if err := script.validate(); err != nil {
+				return nil, fmt.Errorf("scripts[%d]: %w", i, err)
+			}
+		}
+	}
+
+	if config.Routes != nil {
+		for i, route := range *config.Routes {
+			if err := validatePatterns(route.Paths); err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			scroll, err := route.PrerenderAutoScroll()
+			if err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			wait, err := route.PrerenderResponseWait()
+			if err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			if err := (prerender.Options{Actions: route.PrerenderActions(), AutoScroll: scroll, WaitResponse: wait}).Validate(); err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			if route.Renderer != "" {
+				if _, err := prerender.ParseRenderer(route.Renderer); err != nil {
+					return nil, fmt.Errorf("routes[%d]: %w", i, err)
+				}
+			}
+		}
+	}
+
+	if config.Renderer != nil {
+		if _, err := prerender.ParseRenderer(*config.Renderer); err != nil {
+			return nil, fmt.Errorf("renderer: %w", err)
+		}
+	}
+
+	if config.InlineFrames != nil {
+		if err := (prerender.Options{InlineFrames: config.InlineFrames.FrameInlining()}).Validate(); err != nil {
+			return nil, fmt.Errorf("inline_frames: %w", err)
+		}
+	}
+
+	if config.HARDir != nil {
+		if info, err := os.Stat(*config.HARDir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("har_dir: %q is not a directory", *config.HARDir)
+		}
+	}
+	if config.HARThreshold != nil {
+		if _, err := parseDuration("har_threshold", *config.HARThreshold); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ForwardHeaders != nil {
+		for _, name := range *config.ForwardHeaders {
+			if !httpguts.ValidHeaderFieldName(name) {
+				return nil, fmt.Errorf("forward_headers: invalid header name %q", name)
+			}
 		}
 	}
 
 	return &config, nil
 }
+
+// DeviceProfiles returns the built-in device profiles overlaid with the ones
+// configured under devices. Names are case-insensitive.
+func (c *PreCrawlConfig) DeviceProfiles() (map[string]prerender.Device, error) {
+	devices := prerender.DefaultDevices()
+	if c.Devices == nil {
+		return devices, nil
+	}
+	for name, d := range *c.Devices {
+		device := prerender.Device{
+			Width:             d.Width,
+			Height:            d.Height,
+			DeviceScaleFactor: d.DeviceScaleFactor,
+			Mobile:            d.Mobile,
+			Touch:             d.Touch,
+			UserAgent:         d.UserAgent,
+		}
+		if err := device.Validate(); err != nil {
+			return nil, fmt.Errorf("devices.%s: %w", name, err)
+		}
+		devices[strings.ToLower(name)] = device
+	}
+	return devices, nil
+}
+
+// Limits converts the configured limits for the prerender package.
+func (l *LimitsConfig) Limits() (prerender.ResourceLimits, error) {
+	var limits prerender.ResourceLimits
+	if l == nil {
+		return limits, nil
+	}
+	if l.MaxJSHeapMB != nil {
+		if *l.MaxJSHeapMB < 0 {
+			return limits, fmt.Errorf("resource_limits.max_js_heap_mb must not be negative, got %d", *l.MaxJSHeapMB)
+		}
+		limits.MaxJSHeapBytes = *l.MaxJSHeapMB << 20
+	}
+	if l.MaxDOMNodes != nil {
+		if *l.MaxDOMNodes < 0 {
+			return limits, fmt.Errorf("resource_limits.max_dom_nodes must not be negative, got %d", *l.MaxDOMNodes)
+		}
+		limits.MaxDOMNodes = *l.MaxDOMNodes
+	}
+	if l.MaxCPUTime != nil {
+		parsed, err := parseDuration("resource_limits.max_cpu_time", *l.MaxCPUTime)
+		if err != nil {
+			return limits, err
+		}
+		limits.MaxCPUTime = parsed
+	}
+	if l.CheckInterval != nil {
+		parsed, err := parseDuration("resource_limits.check_interval", *l.CheckInterval)
+		if err != nil {
+			return limits, err
+		}
+		limits.CheckInterval = parsed
+	}
+	return limits, nil
+}
+
+func parseDuration(field, raw string) (time.Duration, error) {
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	if parsed < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %s", field, raw)
+	}
+	return parsed, nil
+}
+
+// Validate reports the first invalid browser setting.
+func (b *BrowserConfig) Validate() error {
+	if b.ExecPath != nil {
+		if _, err := exec.LookPath(*b.ExecPath); err != nil {
+			return fmt.Errorf("browser.exec_path: %w", err)
+		}
+	}
+	if b.UserAgent != nil && strings.TrimSpace(*b.UserAgent) == "" {
+		return errors.New("browser.user_agent must not be empty")
+	}
+	if b.WindowSize != nil && (b.WindowSize.Width <= 0 || b.WindowSize.Height <= 0) {
+		return fmt.Errorf("browser.window_size must be positive, got %dx%d", b.WindowSize.Width, b.WindowSize.Height)
+	}
+	if b.ProxyServer != nil {
+		if err := validateProxyServer(*b.ProxyServer); err != nil {
+			return fmt.Errorf("browser.proxy_server: %w", err)
+		}
+	}
+	if b.UserDataDir != nil {
+		if strings.TrimSpace(*b.UserDataDir) == "" {
+			return errors.New("browser.user_data_dir must not be empty")
+		}
+		if info, err := os.Stat(*b.UserDataDir); err == nil && !info.IsDir() {
+			return fmt.Errorf("browser.user_data_dir %s is not a directory", *b.UserDataDir)
+		}
+	}
+	if b.ExtraFlags != nil {
+		for _, raw := range *b.ExtraFlags {
+			if name, _ := parseFlag(raw); name == "" {
+				return fmt.Errorf("browser.extra_flags: invalid flag %q", raw)
+			}
+		}
+	}
+	return nil
+}
+
+// AllocatorOptions maps the browser settings to chromedp allocator options.
+// Options are applied on top of chromedp.DefaultExecAllocatorOptions.
+// UserDataDir is not included, as the pool gives each browser its own
+// profile below it (browser.PoolConfig.UserDataDir).
+func (b *BrowserConfig) AllocatorOptions() []chromedp.ExecAllocatorOption {
+	var opts []chromedp.ExecAllocatorOption
+	if b == nil {
+		return opts
+	}
+	if b.ExecPath != nil {
+		opts = append(opts, chromedp.ExecPath(*b.ExecPath))
+	}
+	if b.UserAgent != nil {
+		opts = append(opts, chromedp.UserAgent(*b.UserAgent))
+	}
+	if b.WindowSize != nil {
+		opts = append(opts, chromedp.WindowSize(b.WindowSize.Width, b.WindowSize.Height))
+	}
+	if b.ProxyServer != nil {
+		opts = append(opts, chromedp.ProxyServer(*b.ProxyServer))
+	}
+	if b.Headless != nil && !*b.Headless {
+		opts = append(opts, chromedp.Flag("headless", false), chromedp.Flag("hide-scrollbars", false), chromedp.Flag("mute-audio", false))
+	}
+	if b.DisableGPU != nil {
+		opts = append(opts, chromedp.Flag("disable-gpu", *b.DisableGPU))
+	}
+	if b.NoSandbox != nil {
+		opts = append(opts, chromedp.Flag("no-sandbox", *b.NoSandbox))
+	}
+	if b.ExtraFlags != nil {
+		for _, raw := range *b.ExtraFlags {
+			name, value := parseFlag(raw)
+			opts = append(opts, chromedp.Flag(name, value))
+		}
+	}
+	return opts
+}
+
+// parseFlag splits "--name=value" into its name and value; bare flags map to true.
+func parseFlag(raw string) (string, any) {
+	raw = strings.TrimLeft(strings.TrimSpace(raw), "-")
+	name, value, found := strings.Cut(raw, "=")
+	if !found {
+		return name, true
+	}
+	return name, value
+}
+
+func validateProxyServer(raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return errors.New("must not be empty")
+	}
+	if strings.Contains(raw, "://") {
+		proxyURL, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		if proxyURL.Host == "" {
+			return fmt.Errorf("missing host in %q", raw)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(raw); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigWithoutTransformers(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := LoadConfig([]byte(`base_target_url: "https://example.com"`))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.BaseTargetURL == nil || *cfg.BaseTargetURL != "https://example.com" {
+		t.Fatalf("unexpected base target url: %v", cfg.BaseTargetURL)
+	}
+}
+
+func TestLoadConfigPoolBounds(t *testing.T) {
+	t.Parallel()
+
+	if _, err := LoadConfig([]byte("pool_min_size: 4\npool_max_size: 2\n")); err == nil {
+		t.Fatal("expected error for pool_max_size below pool_min_size")
+	}
+	if _, err := LoadConfig([]byte("pool_min_size: 0\n")); err == nil {
+		t.Fatal("expected error for non-positive pool_min_size")
+	}
+	if _, err := LoadConfig([]byte("pool_min_size: 1\npool_max_size: 8\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfigBrowser(t *testing.T) {
+	t.Parallel()
+
+	source := `
+browser:
+  user_agent: "precrawl-test"
+  window_size:
+    width: 1280
+    height: 800
+  proxy_server: "http://127.0.0.1:3128"
+  disable_gpu: true
+  no_sandbox: true
+  extra_flags:
+    - "--lang=en-US"
+    - "--disable-notifications"
+`
+	cfg, err := LoadConfig([]byte(source))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if got := len(cfg.Browser.AllocatorOptions()); got != 7 {
+		t.Fatalf("expected 7 allocator options, got %d", got)
+	}
+}
+
+func TestLoadConfigBrowserValidation(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	cases := map[string]string{
+		"browser.exec_path":     "browser:\n  exec_path: /nonexistent/chrome\n",
+		"browser.window_size":   "browser:\n  window_size:\n    width: 0\n    height: 800\n",
+		"browser.proxy_server":  "browser:\n  proxy_server: \"not a proxy\"\n",
+		"browser.user_data_dir": "browser:\n  user_data_dir: " + file + "\n",
+		"browser.extra_flags":   "browser:\n  extra_flags:\n    - \"--\"\n",
+		"browser.user_agent":    "browser:\n  user_agent: \" \"\n",
+	}
+	for field, source := range cases {
+		_, err := LoadConfig([]byte(source))
+		if err == nil {
+			t.Fatalf("expected error for %s", field)
+		}
+		if !strings.Contains(err.Error(), field) {
+			t.Fatalf("expected error mentioning %s, got %v", field, err)
+		}
+	}
+}
+
+func TestParseFlag(t *testing.T) {
+	t.Parallel()
+
+	if name, value := parseFlag("--lang=en-US"); name != "lang" || value != "en-US" {
+		t.Fatalf("unexpected flag %s=%v", name, value)
+	}
+	if name, value := parseFlag("disable-notifications"); name != "disable-notifications" || value != true {
+		t.Fatalf("unexpected flag %s=%v", name, value)
+	}
+}
+
+func TestLoadConfigResourceLimits(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := LoadConfig([]byte("resource_limits:\n  max_js_heap_mb: 256\n  max_dom_nodes: 50000\n  max_cpu_time: 10s\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	limits, err := cfg.ResourceLimits.Limits()
+	if err != nil {
+		t.Fatalf("Limits error: %v", err)
+	}
+	if limits.MaxJSHeapBytes != 256<<20 || limits.MaxDOMNodes != 50000 || limits.MaxCPUTime != 10*time.Second {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+
+	if _, err := LoadConfig([]byte("resource_limits:\n  max_cpu_time: soon\n")); err == nil || !strings.Contains(err.Error(), "resource_limits.max_cpu_time") {
+		t.Fatalf("expected max_cpu_time error, got %v", err)
+	}
+}
+
+func TestLoadConfigDevices(t *testing.T) {
+	t.Parallel()
+
+	source := `
+default_device: Kiosk
+devices:
+  Kiosk:
+    width: 1080
+    height: 1920
+    device_scale_factor: 1
+    touch: true
+`
+	cfg, err := LoadConfig([]byte(source))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	devices, err := cfg.DeviceProfiles()
+	if err != nil {
+		t.Fatalf("DeviceProfiles error: %v", err)
+	}
+	if kiosk, ok := devices["kiosk"]; !ok || kiosk.Width != 1080 || !kiosk.Touch {
+		t.Fatalf("unexpected kiosk profile: %+v", kiosk)
+	}
+	if _, ok := devices["mobile"]; !ok {
+		t.Fatal("expected built-in profiles to be kept")
+	}
+
+	if _, err := LoadConfig([]byte("default_device: watch\n")); err == nil || !strings.Contains(err.Error(), "default_device") {
+		t.Fatalf("expected default_device error, got %v", err)
+	}
+	if _, err := LoadConfig([]byte("devices:\n  tiny:\n    width: 0\n    height: 10\n")); err == nil || !strings.Contains(err.Error(), "devices.tiny") {
+		t.Fatalf("expected devices.tiny error, got %v", err)
+	}
+}
+
+func TestLoadConfigLocaleDefaults(t *testing.T) {
+	t.Parallel()
+
+	source := "default_locale: de-DE\ndefault_timezone: Europe/Berlin\ndefault_geolocation:\n  latitude: 52.52\n  longitude: 13.405\n"
+	cfg, err := LoadConfig([]byte(source))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if geo := cfg.DefaultGeolocation.Geolocation(); geo == nil || geo.Latitude != 52.52 {
+		t.Fatalf("unexpected geolocation: %+v", geo)
+	}
+
+	cases := map[string]string{
+		"default_locale":      "default_locale: \"en_US!\"\n",
+		"default_timezone":    "default_timezone: Nowhere/City\n",
+		"default_geolocation": "default_geolocation:\n  latitude: 0\n  longitude: 200\n",
+	}
+	for field, source := range cases {
+		if _, err := LoadConfig([]byte(source)); err == nil || !strings.Contains(err.Error(), field) {
+			t.Fatalf("expected %s error, got %v", field, err)
+		}
+	}
+}
+
+func TestLoadConfigHosts(t *testing.T) {
+	t.Parallel()
+
+	source := `
+hosts:
+  staging.example.com:
+    headers:
+      X-Staging-Bypass: secret
+    cookies:
+      - name: session
+        value: abc
+        path: /
+        http_only: true
+extra_header_allowlist: [X-Preview-Token]
+extra_cookie_allowlist: [ab_test]
+`
+	cfg, err := LoadConfig([]byte(source))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	options := (*cfg.Hosts)["staging.example.com"].Options()
+	if options.Headers["X-Staging-Bypass"] != "secret" || len(options.Cookies) != 1 || !options.Cookies[0].HTTPOnly {
+		t.Fatalf("unexpected host options: %+v", options)
+	}
+
+	if _, err := LoadConfig([]byte("hosts:\n  example.com:\n    cookies:\n      - name: \"\"\n")); err == nil || !strings.Contains(err.Error(), "hosts.example.com") {
+		t.Fatalf("expected hosts.example.com error, got %v", err)
+	}
+}
+
+func TestLoadConfigForwardHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := LoadConfig([]byte("forward_headers: [Accept-Language, X-Forwarded-For, Cookie]\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if got := len(*cfg.ForwardHeaders); got != 3 {
+		t.Fatalf("expected 3 forwarded headers, got %d", got)
+	}
+	if _, err := LoadConfig([]byte("forward_headers: [\"Bad Header\"]\n")); err == nil || !strings.Contains(err.Error(), "forward_headers") {
+		t.Fatalf("expected forward_headers error, got %v", err)
+	}
+}
+
+func TestLoadConfigScripts(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "stub.js")
+	if err := os.WriteFile(file, []byte("window.__stub = true;"), 0o600); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	source := "scripts:\n  - inline: \"Object.defineProperty(navigator, 'webdriver', {get: () => undefined})\"\n  - file: " + file + "\n    paths: [\"/app/*\"]\n"
+	cfg, err := LoadConfig([]byte(source))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	script, err := (*cfg.Scripts)[1].Source()
+	if err != nil || script != "window.__stub = true;" {
+		t.Fatalf("unexpected script source %q: %v", script, err)
+	}
+
+	cases := []string{
+		"scripts:\n  - paths: [\"/\"]\n",
+		"scripts:\n  - inline: x\n    file: " + file + "\n",
+		"scripts:\n  - file: /nonexistent/script.js\n",
+		"scripts:\n  - inline: x\n    paths: [\"[\"]\n",
+	}
+	for _, source := range cases {
+		if _, err := LoadConfig([]byte(source)); err == nil || !strings.Contains(err.Error(), "scripts[0]") {
+			t.Fatalf("expected scripts[0] error for %q, got %v", source, err)
+		}
+	}
+}
+
+func TestLoadConfigRoutes(t *testing.T) {
+	t.Parallel()
+
+	source := `
+routes:
+  - paths: ["/products/*"]
+    actions:
+      - type: click
+        selector: ".load-more"
+        optional: true
+      - type: wait-ms
+        ms: 500
+`
+	cfg, err := LoadConfig([]byte(source))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	actions := (*cfg.Routes)[0].PrerenderActions()
+	if len(actions) != 2 || actions[0].Selector != ".load-more" || !actions[0].Optional || actions[1].Ms != 500 {
+		t.Fatalf("unexpected actions: %+v", actions)
+	}
+
+	if _, err := LoadConfig([]byte("routes:\n  - actions:\n      - type: click\n")); err == nil || !strings.Contains(err.Error(), "routes[0]") {
+		t.Fatalf("expected routes[0] error, got %v", err)
+	}
+}
+
+func TestLoadConfigAutoScroll(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := LoadConfig([]byte("routes:\n  - paths: [\"/feed\"]\n    auto_scroll:\n      delay: 400ms\n      max_steps: 20\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	scroll, err := (*cfg.Routes)[0].PrerenderAutoScroll()
+	if err != nil {
+		t.Fatalf("PrerenderAutoScroll error: %v", err)
+	}
+	if scroll == nil || scroll.Delay != 400*time.Millisecond || scroll.MaxSteps != 20 {
+		t.Fatalf("unexpected auto-scroll: %+v", scroll)
+	}
+
+	if _, err := LoadConfig([]byte("routes:\n  - auto_scroll:\n      max_duration: forever\n")); err == nil || !strings.Contains(err.Error(), "auto_scroll.max_duration") {
+		t.Fatalf("expected auto_scroll.max_duration error, got %v", err)
+	}
+	if _, err := LoadConfig([]byte("routes:\n  - auto_scroll:\n      step_px: -1\n")); err == nil || !strings.Contains(err.Error(), "routes[0]") {
+		t.Fatalf("expected routes[0] error, got %v", err)
+	}
+}
+
+func TestLoadConfigHAR(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if _, err := LoadConfig([]byte("har_dir: " + dir + "\nhar_threshold: 5s\n")); err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if _, err := LoadConfig([]byte("har_dir: " + filepath.Join(dir, "missing") + "\n")); err == nil || !strings.Contains(err.Error(), "har_dir") {
+		t.Fatalf("expected har_dir error, got %v", err)
+	}
+	if _, err := LoadConfig([]byte("har_threshold: slow\n")); err == nil || !strings.Contains(err.Error(), "har_threshold") {
+		t.Fatalf("expected har_threshold error, got %v", err)
+	}
+}
+
+func TestLoadConfigRenderer(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := LoadConfig([]byte("renderer: auto\nroutes:\n  - paths: [\"/static/*\"]\n    renderer: http\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.Renderer == nil || *cfg.Renderer != "auto" {
+		t.Fatalf("expected renderer auto, got %v", cfg.Renderer)
+	}
+	if got := (*cfg.Routes)[0].Renderer; got != "http" {
+		t.Fatalf("expected route renderer http, got %q", got)
+	}
+	if _, err := LoadConfig([]byte("renderer: curl\n")); err == nil || !strings.Contains(err.Error(), "renderer") {
+		t.Fatalf("expected renderer error, got %v", err)
+	}
+	if _, err := LoadConfig([]byte("routes:\n  - renderer: webkit\n")); err == nil || !strings.Contains(err.Error(), "routes[0]") {
+		t.Fatalf("expected routes[0] error, got %v", err)
+	}
+}
+
+func TestLoadConfigWaitResponse(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := LoadConfig([]byte("routes:\n  - paths: [\"/products/*\"]\n    wait_response:\n      url: /api/product/*\n      status: 200\n      timeout: 3s\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	wait, err := (*cfg.Routes)[0].PrerenderResponseWait()
+	if err != nil || wait == nil || wait.URL != "/api/product/*" || wait.Status != 200 || wait.Timeout != 3*time.Second {
+		t.Fatalf("unexpected response wait: %+v, %v", wait, err)
+	}
+	if _, err := LoadConfig([]byte("routes:\n  - wait_response:\n      status: 200\n")); err == nil || !strings.Contains(err.Error(), "routes[0]") {
+		t.Fatalf("expected routes[0] error for missing url, got %v", err)
+	}
+	if _, err := LoadConfig([]byte("routes:\n  - wait_response:\n      url: /api\n      timeout: soon\n")); err == nil || !strings.Contains(err.Error(), "wait_response.timeout") {
+		t.Fatalf("expected wait_response.timeout error, got %v", err)
+	}
+}
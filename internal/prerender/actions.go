@@ -0,0 +1,135 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// defaultActionTimeout bounds an action's wait for its selector when the
+// action does not set TimeoutMs.
+const defaultActionTimeout = 10 * time.Second
+
+var (
+	ErrInvalidAction = errors.New("invalid action")
+	ErrActionFailed  = errors.New("action failed")
+)
+
+// ActionType names an interaction step.
+type ActionType string
+
+const (
+	ActionClick           ActionType = "click"
+	ActionTypeText        ActionType = "type"
+	ActionScroll          ActionType = "scroll"
+	ActionWaitForSelector ActionType = "wait-for-selector"
+	ActionWaitMs          ActionType = "wait-ms"
+	ActionEvaluate        ActionType = "evaluate"
+)
+
+// Action is a declarative interaction step run after the initial wait and
+// before capture.
+type Action struct {
+	Type ActionType `json:"type"`
+	// Selector is the CSS selector for click, type, wait-for-selector and
+	// scroll. A scroll without selector scrolls the window by Y pixels.
+	Selector string `json:"selector,omitempty"`
+	// Text is typed into the element for type.
+	Text string `json:"text,omitempty"`
+	// Script is evaluated for evaluate; a returned promise is awaited.
+	Script string `json:"script,omitempty"`
+	// Ms is the sleep duration for wait-ms.
+	Ms int `json:"ms,omitempty"`
+	// Y is the number of pixels scrolled for scroll without selector.
+	Y int `json:"y,omitempty"`
+	// TimeoutMs bounds the wait for Selector. Defaults to 10s.
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+	// Optional skips the action instead of failing the render when its
+	// selector does not appear in time, e.g. for a modal that may not show.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Validate reports whether the action has the fields its type needs.
+func (a Action) Validate() error {
+	switch a.Type {
+	case ActionClick, ActionTypeText, ActionWaitForSelector:
+		if a.Selector == "" {
+			return fmt.Errorf("%w: %s requires a selector", ErrInvalidAction, a.Type)
+		}
+	case ActionScroll:
+	case ActionWaitMs:
+		if a.Ms <= 0 {
+			return fmt.Errorf("%w: wait-ms requires a positive ms", ErrInvalidAction)
+		}
+	case ActionEvaluate:
+		if a.Script == "" {
+			return fmt.Errorf("%w: evaluate requires a script", ErrInvalidAction)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidAction, a.Type)
+	}
+	if a.TimeoutMs < 0 {
+		return fmt.Errorf("%w: timeout_ms must not be negative", ErrInvalidAction)
+	}
+	return nil
+}
+
+func (a Action) timeout() time.Duration {
+	if a.TimeoutMs > 0 {
+		return time.Duration(a.TimeoutMs) * time.Millisecond
+	}
+	return defaultActionTimeout
+}
+
+// runActions executes actions in order and stops at the first failure.
+func runActions(ctx context.Context, actions []Action) error {
+	for i, action := range actions {
+		if err := runAction(ctx, action); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			return fmt.Errorf("%w: step %d (%s): %v", ErrActionFailed, i, action.Type, err)
+		}
+	}
+	return nil
+}
+
+func runAction(ctx context.Context, a Action) error {
+	switch a.Type {
+	case ActionWaitMs:
+		return chromedp.Run(ctx, chromedp.Sleep(time.Duration(a.Ms)*time.Millisecond))
+	case ActionEvaluate:
+		return chromedp.Run(ctx, chromedp.Evaluate(a.Script, nil, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		}))
+	case ActionScroll:
+		if a.Selector == "" {
+			return chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf("window.scrollBy(0, %d)", a.Y), nil))
+		}
+	}
+
+	// the remaining actions need their element to be visible first
+	waitCtx, cancel := context.WithTimeout(ctx, a.timeout())
+	err := chromedp.Run(waitCtx, chromedp.WaitVisible(a.Selector, chromedp.ByQuery))
+	cancel()
+	if err != nil {
+		if a.Optional && ctx.Err() == nil {
+			return nil
+		}
+		return err
+	}
+
+	switch a.Type {
+	case ActionClick:
+		return chromedp.Run(ctx, chromedp.Click(a.Selector, chromedp.ByQuery))
+	case ActionTypeText:
+		return chromedp.Run(ctx, chromedp.SendKeys(a.Selector, a.Text, chromedp.ByQuery))
+	case ActionScroll:
+		return chromedp.Run(ctx, chromedp.ScrollIntoView(a.Selector, chromedp.ByQuery))
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var ErrInvalidArchive = errors.New("invalid mhtml archive")
+
+// cssURLPattern matches url(...) references in stylesheets.
+var cssURLPattern = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+
+// captureMHTML returns the page as an MHTML archive via Page.captureSnapshot.
+func captureMHTML(ctx context.Context) ([]byte, error) {
+	var snapshot string
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		snapshot, err = page.CaptureSnapshot().WithFormat(page.CaptureSnapshotFormatMhtml).Do(ctx)
+		return err
+	}))
+	return []byte(snapshot), err
+}
+
+// archiveResource is a single part of an MHTML archive.
+type archiveResource struct {
+	contentType string
+	body        []byte
+}
+
+// SingleFileHTML converts an MHTML archive into one HTML document whose
+// images and stylesheets are inlined as data URIs and <style> elements.
+func SingleFileHTML(archive []byte) ([]byte, error) {
+	root, resources, err := parseMHTML(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := html.Parse(bytes.NewReader(resources[root].body))
+	if err != nil {
+		return nil, err
+	}
+	base, _ := url.Parse(root)
+
+	var traverse func(*html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			inlineElement(n, base, resources)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inlineElement(n *html.Node, base *url.URL, resources map[string]archiveResource) {
+	if n.Data == "link" {
+		for i, a := range n.Attr {
+			if a.Key == "href" {
+				inlineLink(n, i, base, resources)
+				return
+			}
+		}
+		return
+	}
+
+	for i, a := range n.Attr {
+		switch {
+		case a.Key == "style":
+			n.Attr[i].Val = inlineCSSURLs(a.Val, base, resources)
+		case a.Key == "src" && (n.Data == "img" || n.Data == "input" || n.Data == "source"):
+			if uri, ok := dataURI(a.Val, base, resources); ok {
+				n.Attr[i].Val = uri
+			}
+		}
+	}
+	if n.Data == "style" && n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+		n.FirstChild.Data = inlineCSSURLs(n.FirstChild.Data, base, resources)
+	}
+}
+
+// inlineLink replaces a stylesheet link with a <style> element and other
+// links (e.g. icons) with data URIs.
+func inlineLink(n *html.Node, hrefIndex int, base *url.URL, resources map[string]archiveResource) {
+	ref := resolve(base, n.Attr[hrefIndex].Val)
+	resource, ok := resources[ref]
+	if !ok {
+		return
+	}
+
+	for _, a := range n.Attr {
+		if a.Key == "rel" && strings.EqualFold(strings.TrimSpace(a.Val), "stylesheet") {
+			cssBase, _ := url.Parse(ref)
+			n.Data = "style"
+			n.DataAtom = atom.Style
+			n.Attr = nil
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: inlineCSSURLs(string(resource.body), cssBase, resources)})
+			return
+		}
+	}
+
+	if uri, ok := dataURI(ref, nil, resources); ok {
+		n.Attr[hrefIndex].Val = uri
+	}
+}
+
+func inlineCSSURLs(css string, base *url.URL, resources map[string]archiveResource) string {
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		ref := cssURLPattern.FindStringSubmatch(match)[2]
+		if uri, ok := dataURI(ref, base, resources); ok {
+			return `url("` + uri + `")`
+		}
+		return match
+	})
+}
+
+func dataURI(ref string, base *url.URL, resources map[string]archiveResource) (string, bool) {
+	if strings.HasPrefix(ref, "data:") {
+		return "", false
+	}
+	resource, ok := resources[resolve(base, ref)]
+	if !ok {
+		return "", false
+	}
+	return "data:" + resource.contentType + ";base64," + base64.StdEncoding.EncodeToString(resource.body), true
+}
+
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if base == nil {
+		return ref
+	}
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(parsed).String()
+}
+
+// parseMHTML splits an archive into its parts keyed by Content-Location and
+// returns the location of the root HTML document.
+func parseMHTML(archive []byte) (string, map[string]archiveResource, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(archive))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return "", nil, fmt.Errorf("%w: not a multipart archive", ErrInvalidArchive)
+	}
+
+	var root string
+	resources := make(map[string]archiveResource)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		var body io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		location := part.Header.Get("Content-Location")
+		contentType := part.Header.Get("Content-Type")
+		if root == "" && strings.HasPrefix(contentType, "text/html") {
+			root = location
+		}
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+			contentType = mediaType
+		}
+		resources[location] = archiveResource{contentType: contentType, body: data}
+	}
+
+	if root == "" {
+		return "", nil, fmt.Errorf("%w: no html document", ErrInvalidArchive)
+	}
+	return root, resources, nil
+}
@@ -0,0 +1,77 @@
+package prerender
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidDevice = errors.New("invalid device profile")
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+)
+
+// Device describes the viewport and user agent a page is rendered with.
+type Device struct {
+	Width             int
+	Height            int
+	DeviceScaleFactor float64
+	Mobile            bool
+	Touch             bool
+	// UserAgent overrides the browser's user agent; empty keeps the default.
+	UserAgent string
+}
+
+// DefaultDevices returns the built-in device profiles.
+func DefaultDevices() map[string]Device {
+	return map[string]Device{
+		DeviceDesktop: {
+			Width:             1920,
+			Height:            1080,
+			DeviceScaleFactor: 1,
+		},
+		DeviceMobile: {
+			Width:             412,
+			Height:            915,
+			DeviceScaleFactor: 2.625,
+			Mobile:            true,
+			Touch:             true,
+			UserAgent:         "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36",
+		},
+		DeviceTablet: {
+			Width:             820,
+			Height:            1180,
+			DeviceScaleFactor: 2,
+			Mobile:            true,
+			Touch:             true,
+			UserAgent:         "Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		},
+	}
+}
+
+// DeviceForUserAgent picks a built-in profile name for a client user agent,
+// e.g. Googlebot Smartphone maps to DeviceMobile.
+func DeviceForUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "android") || strings.Contains(ua, "iphone"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// Validate reports whether the profile can be applied.
+func (d Device) Validate() error {
+	if d.Width <= 0 || d.Height <= 0 {
+		return fmt.Errorf("%w: width and height must be positive, got %dx%d", ErrInvalidDevice, d.Width, d.Height)
+	}
+	if d.DeviceScaleFactor < 0 {
+		return fmt.Errorf("%w: device scale factor must not be negative", ErrInvalidDevice)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package prerender
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// maxDiagnostics caps each diagnostics list so a noisy page cannot grow a
+// render's memory without bound. Entries beyond the cap are counted in Dropped.
+const maxDiagnostics = 200
+
+// Diagnostics describes what went wrong inside the page during a render.
+type Diagnostics struct {
+	Console        []ConsoleMessage `json:"console"`
+	Exceptions     []Exception      `json:"exceptions"`
+	FailedRequests []FailedRequest  `json:"failed_requests"`
+	Dropped        int              `json:"dropped,omitempty"`
+}
+
+// ConsoleMessage is a console API call such as console.error.
+type ConsoleMessage struct {
+	Level string `json:"level"`
+	Text  string `json:"text"`
+	URL   string `json:"url,omitempty"`
+	Line  int64  `json:"line,omitempty"`
+}
+
+// Exception is an uncaught JavaScript exception. Line and Column are 0-based.
+type Exception struct {
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+	Line    int64  `json:"line"`
+	Column  int64  `json:"column"`
+}
+
+// FailedRequest is a request that failed to load or got an HTTP error status.
+type FailedRequest struct {
+	URL          string `json:"url"`
+	Method       string `json:"method,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	Status       int64  `json:"status,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ConsoleErrors counts console messages logged at error or assert level.
+func (d Diagnostics) ConsoleErrors() int {
+	count := 0
+	for _, m := range d.Console {
+		if m.Level == string(runtime.APITypeError) || m.Level == string(runtime.APITypeAssert) {
+			count++
+		}
+	}
+	return count
+}
+
+// diagnosticsRecorder collects Diagnostics from CDP events.
+type diagnosticsRecorder struct {
+	mu       sync.Mutex
+	d        Diagnostics
+	requests map[network.RequestID]*network.Request
+}
+
+func newDiagnosticsRecorder() *diagnosticsRecorder {
+	return &diagnosticsRecorder{requests: make(map[network.RequestID]*network.Request)}
+}
+
+// record is a chromedp target listener; it must not block.
+func (r *diagnosticsRecorder) record(ev any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch ev := ev.(type) {
+	case *runtime.EventConsoleAPICalled:
+		msg := ConsoleMessage{Level: string(ev.Type), Text: consoleText(ev.Args)}
+		if ev.StackTrace != nil && len(ev.StackTrace.CallFrames) > 0 {
+			msg.URL = ev.StackTrace.CallFrames[0].URL
+			msg.Line = ev.StackTrace.CallFrames[0].LineNumber
+		}
+		appendCapped(&r.d, &r.d.Console, msg)
+	case *runtime.EventExceptionThrown:
+		details := ev.ExceptionDetails
+		if details == nil {
+			return
+		}
+		exc := Exception{Message: details.Text, URL: details.URL, Line: details.LineNumber, Column: details.ColumnNumber}
+		if details.Exception != nil && details.Exception.Description != "" {
+			exc.Message = details.Exception.Description
+		}
+		appendCapped(&r.d, &r.d.Exceptions, exc)
+	case *network.EventRequestWillBeSent:
+		if len(r.requests) < maxDiagnostics*10 {
+			r.requests[ev.RequestID] = ev.Request
+		}
+	case *network.EventResponseReceived:
+		if ev.Response != nil && ev.Response.Status >= 400 {
+			failed := r.failedRequest(ev.RequestID, ev.Type)
+			failed.URL = ev.Response.URL
+			failed.Status = ev.Response.Status
+			appendCapped(&r.d, &r.d.FailedRequests, failed)
+		}
+	case *network.EventLoadingFinished:
+		delete(r.requests, ev.RequestID)
+	case *network.EventLoadingFailed:
+		failed := r.failedRequest(ev.RequestID, ev.Type)
+		failed.Error = ev.ErrorText
+		if ev.BlockedReason != "" {
+			failed.Error += " (" + string(ev.BlockedReason) + ")"
+		}
+		delete(r.requests, ev.RequestID)
+		appendCapped(&r.d, &r.d.FailedRequests, failed)
+	}
+}
+
+func (r *diagnosticsRecorder) failedRequest(id network.RequestID, resourceType network.ResourceType) FailedRequest {
+	failed := FailedRequest{ResourceType: string(resourceType)}
+	if req, ok := r.requests[id]; ok && req != nil {
+		failed.URL = req.URL
+		failed.Method = req.Method
+	}
+	return failed
+}
+
+func (r *diagnosticsRecorder) snapshot() Diagnostics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.d
+	d.Console = append([]ConsoleMessage(nil), d.Console...)
+	d.Exceptions = append([]Exception(nil), d.Exceptions...)
+	d.FailedRequests = append([]FailedRequest(nil), d.FailedRequests...)
+	return d
+}
+
+func appendCapped[T any](d *Diagnostics, list *[]T, entry T) {
+	if len(*list) >= maxDiagnostics {
+		d.Dropped++
+		return
+	}
+	*list = append(*list, entry)
+}
+
+// consoleText joins console arguments the way DevTools prints them.
+func consoleText(args []*runtime.RemoteObject) string {
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == nil {
+			continue
+		}
+		var s string
+		switch {
+		case arg.Type == runtime.TypeString && json.Unmarshal(arg.Value, &s) == nil:
+			parts = append(parts, s)
+		case len(arg.Value) > 0:
+			parts = append(parts, string(arg.Value))
+		case arg.Description != "":
+			parts = append(parts, arg.Description)
+		default:
+			parts = append(parts, string(arg.Type))
+		}
+	}
+	return strings.Join(parts, " ")
+}
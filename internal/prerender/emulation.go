@@ -0,0 +1,195 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // validate timezone IDs on hosts without a zoneinfo database
+
+	cdpbrowser "github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/chromedp"
+)
+
+var (
+	ErrInvalidLocale      = errors.New("invalid locale")
+	ErrInvalidTimezone    = errors.New("invalid timezone")
+	ErrInvalidGeolocation = errors.New("invalid geolocation")
+)
+
+// localePattern accepts BCP 47 style tags such as "en", "de-DE" or "zh-Hant-TW".
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Geolocation is an emulated position. Accuracy is in meters.
+type Geolocation struct {
+	Latitude  float64
+	Longitude float64
+	Accuracy  float64
+}
+
+// ParseGeolocation parses "latitude,longitude[,accuracy]".
+func ParseGeolocation(raw string) (*Geolocation, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("%w: expected latitude,longitude[,accuracy], got %q", ErrInvalidGeolocation, raw)
+	}
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGeolocation, err)
+		}
+		values[i] = value
+	}
+
+	geo := &Geolocation{Latitude: values[0], Longitude: values[1]}
+	if len(values) == 3 {
+		geo.Accuracy = values[2]
+	}
+	return geo, geo.Validate()
+}
+
+// Validate reports whether the position is within range.
+func (g Geolocation) Validate() error {
+	if g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 || g.Accuracy < 0 {
+		return fmt.Errorf("%w: %g,%g accuracy %g is out of range", ErrInvalidGeolocation, g.Latitude, g.Longitude, g.Accuracy)
+	}
+	return nil
+}
+
+// ValidateLocale reports whether locale looks like a BCP 47 language tag.
+func ValidateLocale(locale string) error {
+	if !localePattern.MatchString(locale) {
+		return fmt.Errorf("%w: %q", ErrInvalidLocale, locale)
+	}
+	return nil
+}
+
+// ValidateTimezone reports whether timezone is a known IANA timezone ID.
+func ValidateTimezone(timezone string) error {
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "" || timezone == "Local" {
+		return fmt.Errorf("%w: %q", ErrInvalidTimezone, timezone)
+	}
+	return nil
+}
+
+func (o Options) validateEmulation() error {
+	if o.Device != nil {
+		if err := o.Device.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Locale != "" {
+		if err := ValidateLocale(o.Locale); err != nil {
+			return err
+		}
+	}
+	if o.Timezone != "" {
+		if err := ValidateTimezone(o.Timezone); err != nil {
+			return err
+		}
+	}
+	if o.Geolocation != nil {
+		return o.Geolocation.Validate()
+	}
+	return nil
+}
+
+func (o Options) emulates() bool {
+	return o.Device != nil || o.Locale != "" || o.AcceptLanguage != "" || o.Timezone != "" || o.Geolocation != nil ||
+		o.DisableJavaScript
+}
+
+// emulate applies the device, locale, timezone and geolocation overrides and
+// disables JavaScript on the page before navigation.
+func emulate(ctx context.Context, o Options) error {
+	return chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if d := o.Device; d != nil {
+			if err := emulation.SetDeviceMetricsOverride(int64(d.Width), int64(d.Height), d.DeviceScaleFactor, d.Mobile).Do(ctx); err != nil {
+				return err
+			}
+			if err := emulation.SetTouchEmulationEnabled(d.Touch).Do(ctx); err != nil {
+				return err
+			}
+		}
+
+		userAgent := ""
+		if o.Device != nil {
+			userAgent = o.Device.UserAgent
+		}
+		acceptLanguage := o.AcceptLanguage
+		if acceptLanguage == "" {
+			acceptLanguage = o.Locale
+		}
+		if acceptLanguage != "" && userAgent == "" {
+			// the accept language can only be set together with a user agent
+			_, _, _, ua, _, err := cdpbrowser.GetVersion().Do(ctx)
+			if err != nil {
+				return err
+			}
+			userAgent = ua
+		}
+		if userAgent != "" {
+			params := emulation.SetUserAgentOverride(userAgent)
+			if acceptLanguage != "" {
+				params = params.WithAcceptLanguage(acceptLanguage)
+			}
+			if err := params.Do(ctx); err != nil {
+				return err
+			}
+		}
+
+		if o.Locale != "" {
+			if err := emulation.SetLocaleOverride().WithLocale(o.Locale).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if o.Timezone != "" {
+			if err := emulation.SetTimezoneOverride(o.Timezone).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if g := o.Geolocation; g != nil {
+			if err := cdpbrowser.GrantPermissions([]cdpbrowser.PermissionType{cdpbrowser.PermissionTypeGeolocation}).Do(ctx); err != nil {
+				return err
+			}
+			if err := emulation.SetGeolocationOverride().WithLatitude(g.Latitude).WithLongitude(g.Longitude).WithAccuracy(g.Accuracy).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if o.DisableJavaScript {
+			if err := emulation.SetScriptExecutionDisabled(true).Do(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// resetEmulation clears every override applied by emulate so the page can be
+// reused by the next render. Every reset is attempted even if one fails, so
+// a failing override cannot keep JavaScript disabled on the page.
+func resetEmulation(ctx context.Context, o Options) error {
+	// an empty user agent restores the browser default
+	actions := []chromedp.Action{emulation.SetUserAgentOverride("")}
+	if o.Device != nil {
+		actions = append(actions, emulation.ClearDeviceMetricsOverride(), emulation.SetTouchEmulationEnabled(false))
+	}
+	if o.Locale != "" {
+		actions = append(actions, emulation.SetLocaleOverride())
+	}
+	if o.Timezone != "" {
+		actions = append(actions, emulation.SetTimezoneOverride(""))
+	}
+	if o.DisableJavaScript {
+		actions = append(actions, emulation.SetScriptExecutionDisabled(false))
+	}
+	if o.Geolocation != nil {
+		actions = append(actions, emulation.ClearGeolocationOverride(), cdpbrowser.ResetPermissions())
+	}
+	return runEach(ctx, actions...)
+}
@@ -0,0 +1,229 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultFrameMaxDepth = 2
+	defaultFrameMaxBytes = 1 << 20
+)
+
+var ErrInvalidFrameOptions = errors.New("invalid frame inlining options")
+
+// FrameInlining inlines the rendered documents of same-origin iframes into
+// the HTML output as srcdoc attributes. Cross-origin frames are left as is.
+type FrameInlining struct {
+	// MaxDepth is how many levels of nested frames are inlined. Defaults to 2.
+	MaxDepth int
+	// MaxBytes skips frames whose serialized document is larger. Defaults to 1 MiB.
+	MaxBytes int
+}
+
+func (f FrameInlining) validate() error {
+	if f.MaxDepth < 0 || f.MaxBytes < 0 {
+		return fmt.Errorf("%w: values must not be negative", ErrInvalidFrameOptions)
+	}
+	return nil
+}
+
+// frameAttr tags the owner element of every captured frame with the frame's
+// id, so InlineFrames matches documents to iframes no matter how the
+// serialized HTML is parsed.
+const frameAttr = "data-precrawl-frame"
+
+// tagFrameScript tags an iframe element with a frame id, unless it already
+// uses srcdoc. It is called on the owner element.
+const tagFrameScript = `function() {
+	if (this.hasAttribute('srcdoc')) return false;
+	this.setAttribute('` + frameAttr + `', %q);
+	return true;
+}`
+
+// untagFrameScript removes the tag of an iframe element.
+const untagFrameScript = `function() {
+	this.removeAttribute('` + frameAttr + `');
+	return true;
+}`
+
+// frameDocumentScript serializes the document of the frame it is evaluated in.
+const frameDocumentScript = `document.documentElement ? '<!DOCTYPE html>' + document.documentElement.outerHTML : null`
+
+// frameDocuments walks the frame tree of the page, tags the owner elements of
+// the same-origin frames up to opts.MaxDepth and returns their serialized
+// documents keyed by frame id. It must run before the page is captured so the
+// captured HTML carries the tags. Frames that cannot be read are left out and
+// untagged.
+func frameDocuments(ctx context.Context, opts FrameInlining) (map[string]string, error) {
+	maxDepth := opts.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = defaultFrameMaxDepth
+	}
+	documents := make(map[string]string)
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		tree, err := page.GetFrameTree().Do(ctx)
+		if err != nil {
+			return err
+		}
+		origin := tree.Frame.SecurityOrigin
+
+		// tag every frame first, so the documents of parent frames carry the
+		// tags of their children when serialized
+		var tagged []cdp.FrameID
+		var walk func(children []*page.FrameTree, depth int)
+		walk = func(children []*page.FrameTree, depth int) {
+			if depth > maxDepth {
+				return
+			}
+			for _, child := range children {
+				if child.Frame.SecurityOrigin != origin || !tagFrame(ctx, child.Frame.ID) {
+					continue
+				}
+				tagged = append(tagged, child.Frame.ID)
+				walk(child.ChildFrames, depth+1)
+			}
+		}
+		walk(tree.ChildFrames, 1)
+
+		// frames that cannot be read are untagged, so only frames with a
+		// document carry a tag in the capture
+		for _, id := range tagged {
+			if document, ok := frameDocument(ctx, id); ok {
+				documents[string(id)] = document
+			} else {
+				callOnFrameOwner(ctx, id, untagFrameScript)
+			}
+		}
+		return nil
+	}))
+	return documents, err
+}
+
+// tagFrame sets frameAttr on the owner element of the frame.
+func tagFrame(ctx context.Context, id cdp.FrameID) bool {
+	return callOnFrameOwner(ctx, id, fmt.Sprintf(tagFrameScript, id))
+}
+
+// callOnFrameOwner calls fn on the owner element of the frame and reports
+// whether it returned true.
+func callOnFrameOwner(ctx context.Context, id cdp.FrameID, fn string) bool {
+	owner, _, err := dom.GetFrameOwner(id).Do(ctx)
+	if err != nil {
+		return false
+	}
+	object, err := dom.ResolveNode().WithBackendNodeID(owner).Do(ctx)
+	if err != nil {
+		return false
+	}
+	defer runtime.ReleaseObject(object.ObjectID).Do(ctx)
+
+	res, exp, err := runtime.CallFunctionOn(fn).
+		WithObjectID(object.ObjectID).
+		WithReturnByValue(true).
+		Do(ctx)
+	if err != nil || exp != nil {
+		return false
+	}
+	var ok bool
+	return json.Unmarshal(res.Value, &ok) == nil && ok
+}
+
+// frameDocument serializes the document of the frame in an isolated world, so
+// page scripts cannot interfere.
+func frameDocument(ctx context.Context, id cdp.FrameID) (string, bool) {
+	world, err := page.CreateIsolatedWorld(id).WithWorldName("precrawl").Do(ctx)
+	if err != nil {
+		return "", false
+	}
+	res, exp, err := runtime.Evaluate(frameDocumentScript).
+		WithContextID(world).
+		WithReturnByValue(true).
+		Do(ctx)
+	if err != nil || exp != nil {
+		return "", false
+	}
+	var document *string
+	if json.Unmarshal(res.Value, &document) != nil || document == nil {
+		return "", false
+	}
+	return *document, true
+}
+
+// InlineFrames sets the srcdoc attribute of the iframes in document that are
+// tagged by frameDocuments to the matching entry of frames, inlining nested
+// frames first. Frames whose document ends up larger than maxBytes are left
+// unchanged; a zero maxBytes applies the default. The tags are removed.
+func InlineFrames(document string, frames map[string]string, maxBytes int) (string, error) {
+	if maxBytes == 0 {
+		maxBytes = defaultFrameMaxBytes
+	}
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	var traverse func(*html.Node) error
+	traverse = func(n *html.Node) error {
+		if n.Type == html.ElementNode && n.Data == "iframe" {
+			if id, ok := removeAttr(n, frameAttr); ok {
+				if frame, ok := frames[id]; ok {
+					inlined, err := InlineFrames(frame, frames, maxBytes)
+					if err != nil {
+						return err
+					}
+					if len(inlined) <= maxBytes {
+						setAttr(n, "srcdoc", inlined)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := traverse(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := traverse(doc); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func removeAttr(n *html.Node, key string) (string, bool) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr = slices.Delete(n.Attr, i, i+1)
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,355 @@
+package prerender
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+)
+
+// maxHAREntries caps the entries recorded for one render.
+const maxHAREntries = 2000
+
+// harRedactedHeaders are left out of HAR entries, as they carry credentials.
+var harRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// HAR is an HTTP Archive 1.2 document.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Pages   []HARPage   `json:"pages"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HARPage struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     HARPageTimings `json:"pageTimings"`
+}
+
+// HARPageTimings are milliseconds since the page started; -1 if not reached.
+type HARPageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad"`
+	OnLoad        float64 `json:"onLoad"`
+}
+
+type HAREntry struct {
+	Pageref         string      `json:"pageref"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	// Error is a non-standard field holding the network error of a failed request.
+	Error string `json:"_error,omitempty"`
+
+	started  time.Time
+	timing   *network.ResourceTiming
+	finished bool
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARTimings are in milliseconds; -1 marks phases that did not happen.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harRecorder builds a HAR from CDP network and page events.
+type harRecorder struct {
+	mu        sync.Mutex
+	title     string
+	redact    map[string]bool // lower-case header names left out
+	started   time.Time
+	startedAt time.Time // monotonic timestamp of the first request
+	timings   HARPageTimings
+	entries   []*HAREntry
+	pending   map[network.RequestID]*HAREntry
+}
+
+// newHARRecorder returns a recorder that leaves out harRedactedHeaders and
+// the headers named in redact, e.g. those injected into the render.
+func newHARRecorder(title string, redact []string) *harRecorder {
+	redacted := make(map[string]bool, len(harRedactedHeaders)+len(redact))
+	for _, name := range slices.Concat(harRedactedHeaders, redact) {
+		redacted[strings.ToLower(name)] = true
+	}
+	return &harRecorder{
+		title:   title,
+		redact:  redacted,
+		started: time.Now(),
+		timings: HARPageTimings{OnContentLoad: -1, OnLoad: -1},
+		pending: make(map[network.RequestID]*HAREntry),
+	}
+}
+
+// record is a chromedp target listener; it must not block.
+func (r *harRecorder) record(ev any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		// a redirect reuses the request id; finish the previous hop first
+		if prev, ok := r.pending[ev.RequestID]; ok && ev.RedirectResponse != nil {
+			prev.setResponse(ev.RedirectResponse, r.redact)
+			prev.Response.RedirectURL = ev.Request.URL
+			prev.finish(monotonic(ev.Timestamp), 0)
+			delete(r.pending, ev.RequestID)
+		}
+		if len(r.entries) >= maxHAREntries || ev.Request == nil {
+			return
+		}
+		if r.startedAt.IsZero() {
+			r.startedAt = monotonic(ev.Timestamp)
+		}
+		started := time.Now()
+		if ev.WallTime != nil {
+			started = time.Time(*ev.WallTime)
+		}
+		entry := &HAREntry{
+			Pageref:         "page_1",
+			StartedDateTime: started.UTC().Format(time.RFC3339Nano),
+			Request: HARRequest{
+				Method:      ev.Request.Method,
+				URL:         ev.Request.URL,
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []HARNameValue{},
+				Headers:     harHeaders(ev.Request.Headers, r.redact),
+				QueryString: harQuery(ev.Request.URL),
+				HeadersSize: -1,
+				BodySize:    0,
+			},
+			Response: HARResponse{Cookies: []HARNameValue{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1},
+			Timings:  HARTimings{Blocked: -1, DNS: -1, Connect: -1, Send: 0, Wait: 0, Receive: 0, SSL: -1},
+			started:  monotonic(ev.Timestamp),
+		}
+		r.entries = append(r.entries, entry)
+		r.pending[ev.RequestID] = entry
+	case *network.EventResponseReceived:
+		if entry, ok := r.pending[ev.RequestID]; ok && ev.Response != nil {
+			entry.setResponse(ev.Response, r.redact)
+		}
+	case *network.EventLoadingFinished:
+		if entry, ok := r.pending[ev.RequestID]; ok {
+			entry.finish(monotonic(ev.Timestamp), int64(ev.EncodedDataLength))
+			delete(r.pending, ev.RequestID)
+		}
+	case *network.EventLoadingFailed:
+		if entry, ok := r.pending[ev.RequestID]; ok {
+			entry.Error = ev.ErrorText
+			entry.finish(monotonic(ev.Timestamp), 0)
+			delete(r.pending, ev.RequestID)
+		}
+	case *page.EventDomContentEventFired:
+		r.timings.OnContentLoad = r.sincePageStart(ev.Timestamp)
+	case *page.EventLoadEventFired:
+		r.timings.OnLoad = r.sincePageStart(ev.Timestamp)
+	}
+}
+
+func (r *harRecorder) sincePageStart(ts *cdp.MonotonicTime) float64 {
+	if r.startedAt.IsZero() || ts == nil {
+		return -1
+	}
+	return milliseconds(monotonic(ts).Sub(r.startedAt))
+}
+
+// har returns the recorded archive. Requests still in flight are included
+// without timings.
+func (r *harRecorder) har() *HAR {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]*HAREntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "precrawl", Version: "1.0"},
+		Pages: []HARPage{{
+			StartedDateTime: r.started.UTC().Format(time.RFC3339Nano),
+			ID:              "page_1",
+			Title:           r.title,
+			PageTimings:     r.timings,
+		}},
+		Entries: entries,
+	}}
+}
+
+func (e *HAREntry) setResponse(resp *network.Response, redact map[string]bool) {
+	e.Response.Status = resp.Status
+	e.Response.StatusText = resp.StatusText
+	e.Response.HTTPVersion = harProtocol(resp.Protocol)
+	e.Request.HTTPVersion = e.Response.HTTPVersion
+	e.Response.Headers = harHeaders(resp.Headers, redact)
+	e.Response.Content.MimeType = resp.MimeType
+	e.ServerIPAddress = resp.RemoteIPAddress
+	e.timing = resp.Timing
+	if resp.RequestHeaders != nil {
+		e.Request.Headers = harHeaders(resp.RequestHeaders, redact)
+	}
+}
+
+// finish fills the total time and the phase timings once the request ends.
+func (e *HAREntry) finish(end time.Time, encodedLength int64) {
+	if e.finished {
+		return
+	}
+	e.finished = true
+	if encodedLength > 0 {
+		e.Response.BodySize = encodedLength
+		e.Response.Content.Size = encodedLength
+	}
+	total := 0.0
+	if !e.started.IsZero() && !end.IsZero() {
+		total = max(milliseconds(end.Sub(e.started)), 0)
+	}
+	e.Time = total
+
+	t := e.timing
+	if t == nil {
+		e.Timings.Wait = total
+		return
+	}
+	// ResourceTiming offsets are milliseconds relative to RequestTime
+	phase := func(start, end float64) float64 {
+		if start < 0 || end < 0 {
+			return -1
+		}
+		return end - start
+	}
+	e.Timings.Blocked = max(firstNonNegative(t.DNSStart, t.ConnectStart, t.SendStart), 0)
+	e.Timings.DNS = phase(t.DNSStart, t.DNSEnd)
+	e.Timings.Connect = phase(t.ConnectStart, t.ConnectEnd)
+	e.Timings.SSL = phase(t.SslStart, t.SslEnd)
+	e.Timings.Send = max(t.SendEnd-t.SendStart, 0)
+	e.Timings.Wait = max(t.ReceiveHeadersEnd-t.SendEnd, 0)
+	// RequestTime is on the same monotonic clock as the event timestamps
+	requestStart := cdp.MonotonicTimeEpoch.Add(time.Duration(t.RequestTime * float64(time.Second)))
+	if !end.IsZero() {
+		e.Timings.Receive = max(milliseconds(end.Sub(requestStart))-t.ReceiveHeadersEnd, 0)
+	}
+}
+
+func firstNonNegative(values ...float64) float64 {
+	for _, v := range values {
+		if v >= 0 {
+			return v
+		}
+	}
+	return -1
+}
+
+func monotonic(ts *cdp.MonotonicTime) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return time.Time(*ts)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harHeaders lists headers sorted by name, leaving out those in redact.
+func harHeaders(headers network.Headers, redact map[string]bool) []HARNameValue {
+	list := make([]HARNameValue, 0, len(headers))
+	for name, value := range headers {
+		if redact[strings.ToLower(name)] {
+			continue
+		}
+		// multiple values of one header are joined with newlines by Chrome
+		for _, v := range strings.Split(fmt.Sprint(value), "\n") {
+			list = append(list, HARNameValue{Name: name, Value: v})
+		}
+	}
+	slices.SortStableFunc(list, func(a, b HARNameValue) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+func harQuery(raw string) []HARNameValue {
+	list := []HARNameValue{}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return list
+	}
+	for name, values := range u.Query() {
+		for _, v := range values {
+			list = append(list, HARNameValue{Name: name, Value: v})
+		}
+	}
+	slices.SortStableFunc(list, func(a, b HARNameValue) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+func harProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "h2":
+		return "HTTP/2.0"
+	case "h3":
+		return "HTTP/3.0"
+	case "http/1.0":
+		return "HTTP/1.0"
+	case "":
+		return "HTTP/1.1"
+	}
+	return strings.ToUpper(protocol)
+}
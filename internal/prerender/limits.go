@@ -0,0 +1,143 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/performance"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	defaultCheckInterval = 250 * time.Millisecond
+	sampleTimeout        = 5 * time.Second
+)
+
+var ErrResourceLimit = errors.New("page resource limit exceeded")
+
+// ResourceLimits caps what a single page may use during a render. Zero values
+// disable the corresponding limit.
+type ResourceLimits struct {
+	MaxJSHeapBytes int64
+	MaxDOMNodes    int64
+	// MaxCPUTime caps the main-thread task time of the page. A page that stops
+	// answering metric requests for this long is treated as exceeding it.
+	MaxCPUTime time.Duration
+	// CheckInterval is how often metrics are sampled. Defaults to 250ms.
+	CheckInterval time.Duration
+}
+
+func (l ResourceLimits) enabled() bool {
+	return l.MaxJSHeapBytes > 0 || l.MaxDOMNodes > 0 || l.MaxCPUTime > 0
+}
+
+// PageMetrics is a snapshot of the CDP Performance metrics of a page.
+type PageMetrics struct {
+	JSHeapUsedBytes  int64
+	JSHeapTotalBytes int64
+	DOMNodes         int64
+	CPUTime          time.Duration
+	ScriptDuration   time.Duration
+}
+
+func (m PageMetrics) String() string {
+	return fmt.Sprintf("jsHeapUsed=%d jsHeapTotal=%d domNodes=%d cpuTime=%s scriptDuration=%s",
+		m.JSHeapUsedBytes, m.JSHeapTotalBytes, m.DOMNodes, m.CPUTime.Round(time.Millisecond), m.ScriptDuration.Round(time.Millisecond))
+}
+
+// ResourceLimitError reports which limit a page exceeded. It matches ErrResourceLimit.
+type ResourceLimitError struct {
+	Resource string
+	Value    int64
+	Limit    int64
+	Metrics  PageMetrics
+}
+
+func (e *ResourceLimitError) Error() string {
+	return fmt.Sprintf("%v: %s=%d limit=%d", ErrResourceLimit, e.Resource, e.Value, e.Limit)
+}
+
+func (e *ResourceLimitError) Is(target error) bool {
+	return target == ErrResourceLimit
+}
+
+// check returns a ResourceLimitError for the first limit m exceeds.
+func (l ResourceLimits) check(m PageMetrics) error {
+	switch {
+	case l.MaxJSHeapBytes > 0 && m.JSHeapUsedBytes > l.MaxJSHeapBytes:
+		return &ResourceLimitError{Resource: "jsHeap", Value: m.JSHeapUsedBytes, Limit: l.MaxJSHeapBytes, Metrics: m}
+	case l.MaxDOMNodes > 0 && m.DOMNodes > l.MaxDOMNodes:
+		return &ResourceLimitError{Resource: "domNodes", Value: m.DOMNodes, Limit: l.MaxDOMNodes, Metrics: m}
+	case l.MaxCPUTime > 0 && m.CPUTime > l.MaxCPUTime:
+		return &ResourceLimitError{Resource: "cpuTime", Value: m.CPUTime.Milliseconds(), Limit: l.MaxCPUTime.Milliseconds(), Metrics: m}
+	}
+	return nil
+}
+
+func collectMetrics(ctx context.Context) (PageMetrics, error) {
+	var m PageMetrics
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		metrics, err := performance.GetMetrics().Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, metric := range metrics {
+			switch metric.Name {
+			case "JSHeapUsedSize":
+				m.JSHeapUsedBytes = int64(metric.Value)
+			case "JSHeapTotalSize":
+				m.JSHeapTotalBytes = int64(metric.Value)
+			case "Nodes":
+				m.DOMNodes = int64(metric.Value)
+			case "TaskDuration":
+				m.CPUTime = time.Duration(metric.Value * float64(time.Second))
+			case "ScriptDuration":
+				m.ScriptDuration = time.Duration(metric.Value * float64(time.Second))
+			}
+		}
+		return nil
+	}))
+	return m, err
+}
+
+// monitor samples page metrics until ctx is done and calls abort with a
+// ResourceLimitError once a limit is exceeded.
+func monitor(ctx context.Context, limits ResourceLimits, abort context.CancelCauseFunc) {
+	interval := limits.CheckInterval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		timeout := limits.MaxCPUTime
+		if timeout <= 0 {
+			timeout = sampleTimeout
+		}
+		sampleCtx, cancel := context.WithTimeout(ctx, timeout)
+		m, err := collectMetrics(sampleCtx)
+		unresponsive := errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+
+		if unresponsive && limits.MaxCPUTime > 0 {
+			abort(&ResourceLimitError{Resource: "cpuTime", Value: limits.MaxCPUTime.Milliseconds(), Limit: limits.MaxCPUTime.Milliseconds(), Metrics: m})
+			return
+		}
+		if err != nil {
+			continue
+		}
+		if err := limits.check(m); err != nil {
+			abort(err)
+			return
+		}
+	}
+}
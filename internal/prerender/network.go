@@ -0,0 +1,148 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrInvalidHeader = errors.New("invalid extra header")
+	ErrInvalidCookie = errors.New("invalid cookie")
+)
+
+// Cookie is set in the browser before navigation. An empty Domain scopes the
+// cookie to the target URL's host.
+type Cookie struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	Secure   bool
+	HTTPOnly bool
+}
+
+func (o Options) validateNetwork() error {
+	for name, value := range o.Headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("%w: %q", ErrInvalidHeader, name)
+		}
+	}
+	for _, c := range o.Cookies {
+		if c.Name == "" || strings.ContainsAny(c.Name, "=; \t\r\n") || strings.ContainsAny(c.Value, ";\r\n") {
+			return fmt.Errorf("%w: %q", ErrInvalidCookie, c.Name)
+		}
+	}
+	return nil
+}
+
+// applyNetwork sets the cookies for the navigation to targetURL and adds the
+// extra headers to the requests the page makes to the target's origin.
+func applyNetwork(ctx context.Context, targetURL string, o Options) error {
+	if len(o.Headers) > 0 {
+		if err := scopeHeaders(ctx, targetURL, o.Headers); err != nil {
+			return err
+		}
+	}
+	if len(o.Cookies) == 0 {
+		return nil
+	}
+	params := make([]*network.CookieParam, 0, len(o.Cookies))
+	for _, c := range o.Cookies {
+		param := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+		}
+		if c.Domain == "" {
+			param.URL = targetURL
+		}
+		params = append(params, param)
+	}
+	return chromedp.Run(ctx, network.SetCookies(params))
+}
+
+// scopeHeaders pauses the requests to the origin of targetURL and continues
+// them with headers added. Network.setExtraHTTPHeaders is not used because
+// it sends the headers, often credentials, to every host the page loads from.
+// Each redirect hop is paused again, so a redirect to another origin goes out
+// without them.
+func scopeHeaders(ctx context.Context, targetURL string, headers map[string]string) error {
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return err
+	}
+	origin := requestOrigin(target)
+	chromedp.ListenTarget(ctx, func(ev any) {
+		paused, ok := ev.(*fetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		// CDP calls block until answered, so they cannot run in the listener
+		go func() {
+			params := fetch.ContinueRequest(paused.RequestID)
+			if u, err := url.Parse(paused.Request.URL); err == nil && requestOrigin(u) == origin {
+				params = params.WithHeaders(withHeaders(paused.Request.Headers, headers))
+			}
+			_ = params.Do(cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target))
+		}()
+	})
+	// the pattern only narrows what is paused; the listener checks the origin
+	pattern := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`).Replace(origin) + "/*"
+	return chromedp.Run(ctx, fetch.Enable().WithPatterns([]*fetch.RequestPattern{{URLPattern: pattern}}))
+}
+
+// requestOrigin returns the scheme, host and port of u, with the default port
+// left out as Chrome reports request URLs.
+func requestOrigin(u *url.URL) string {
+	scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return scheme + "://" + host
+}
+
+// withHeaders returns the request headers with extra set, replacing headers
+// of the same name regardless of case.
+func withHeaders(request network.Headers, extra map[string]string) []*fetch.HeaderEntry {
+	replaced := make(map[string]bool, len(extra))
+	for name := range extra {
+		replaced[strings.ToLower(name)] = true
+	}
+	entries := make([]*fetch.HeaderEntry, 0, len(request)+len(extra))
+	for name, value := range request {
+		if replaced[strings.ToLower(name)] {
+			continue
+		}
+		entries = append(entries, &fetch.HeaderEntry{Name: name, Value: fmt.Sprint(value)})
+	}
+	for name, value := range extra {
+		entries = append(entries, &fetch.HeaderEntry{Name: name, Value: value})
+	}
+	slices.SortFunc(entries, func(a, b *fetch.HeaderEntry) int { return strings.Compare(a.Name, b.Name) })
+	return entries
+}
+
+// resetNetwork stops adding the extra headers and removes every cookie so the
+// next render on the page starts without them.
+func resetNetwork(ctx context.Context) error {
+	return runEach(ctx, fetch.Disable(), network.ClearBrowserCookies())
+}
@@ -0,0 +1,170 @@
+package prerender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+var (
+	ErrUnknownFormat   = errors.New("unknown output format")
+	ErrElementNotFound = errors.New("screenshot element not found")
+	ErrInvalidQuality  = errors.New("image quality must be between 0 and 100")
+)
+
+// Format selects what a render produces.
+type Format string
+
+const (
+	FormatHTML Format = "html"
+	FormatPNG  Format = "png"
+	FormatJPEG Format = "jpeg"
+	FormatWebP Format = "webp"
+	FormatPDF  Format = "pdf"
+	// FormatMHTML is a multipart archive of the document and its resources.
+	FormatMHTML Format = "mhtml"
+	// FormatSingleFile is an HTML document with images and stylesheets inlined.
+	FormatSingleFile Format = "singlefile"
+)
+
+// ParseFormat maps a user-supplied format name to a Format. An empty name is FormatHTML.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "", "html":
+		return FormatHTML, nil
+	case "png":
+		return FormatPNG, nil
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "webp":
+		return FormatWebP, nil
+	case "pdf":
+		return FormatPDF, nil
+	case "mhtml", "mht":
+		return FormatMHTML, nil
+	case "singlefile", "single-file":
+		return FormatSingleFile, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// ContentType returns the MIME type of the format's output.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatPNG:
+		return "image/png"
+	case FormatJPEG:
+		return "image/jpeg"
+	case FormatWebP:
+		return "image/webp"
+	case FormatPDF:
+		return "application/pdf"
+	case FormatMHTML:
+		// the multipart boundary is only declared inside the archive, so it
+		// is served as a whole MIME document rather than multipart/related
+		return "application/x-mimearchive"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// Binary reports whether the format produces captured bytes in Result.Data
+// rather than an HTML document for the transformers.
+func (f Format) Binary() bool {
+	return f != "" && f != FormatHTML
+}
+
+func (f Format) image() bool {
+	return f == FormatPNG || f == FormatJPEG || f == FormatWebP
+}
+
+// ScreenshotOptions controls image output. By default the viewport is captured.
+type ScreenshotOptions struct {
+	// FullPage captures the whole scrollable document instead of the viewport.
+	FullPage bool
+	// Selector captures only the first element matching this CSS selector.
+	Selector string
+	// Quality is the JPEG/WebP compression quality (0-100). Zero uses Chrome's default.
+	Quality int
+}
+
+func (o ScreenshotOptions) validate() error {
+	if o.Quality < 0 || o.Quality > 100 {
+		return ErrInvalidQuality
+	}
+	return nil
+}
+
+// screenshot captures the page according to format and opts.
+func screenshot(ctx context.Context, format Format, opts ScreenshotOptions) ([]byte, error) {
+	params := page.CaptureScreenshot().
+		WithFormat(page.CaptureScreenshotFormat(format)).
+		WithFromSurface(true)
+	if opts.Quality > 0 && format != FormatPNG {
+		params = params.WithQuality(int64(opts.Quality))
+	}
+
+	var clip *page.Viewport
+	switch {
+	case opts.Selector != "":
+		var err error
+		if clip, err = elementClip(ctx, opts.Selector); err != nil {
+			return nil, err
+		}
+	case opts.FullPage:
+		var err error
+		if clip, err = fullPageClip(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if clip != nil {
+		params = params.WithClip(clip).WithCaptureBeyondViewport(true)
+	}
+
+	var buf []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		buf, err = params.Do(ctx)
+		return err
+	}))
+	return buf, err
+}
+
+func fullPageClip(ctx context.Context) (*page.Viewport, error) {
+	var clip *page.Viewport
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, _, _, _, contentSize, err := page.GetLayoutMetrics().Do(ctx)
+		if err != nil {
+			return err
+		}
+		clip = &page.Viewport{Width: contentSize.Width, Height: contentSize.Height, Scale: 1}
+		return nil
+	}))
+	return clip, err
+}
+
+func elementClip(ctx context.Context, selector string) (*page.Viewport, error) {
+	quoted, err := json.Marshal(selector)
+	if err != nil {
+		return nil, err
+	}
+	script := fmt.Sprintf(`(() => {
+		const el = document.querySelector(%s);
+		if (!el) return null;
+		const r = el.getBoundingClientRect();
+		return {x: r.left + window.scrollX, y: r.top + window.scrollY, width: r.width, height: r.height};
+	})()`, quoted)
+
+	var clip *page.Viewport
+	if err := chromedp.Run(ctx, chromedp.Evaluate(script, &clip)); err != nil {
+		return nil, err
+	}
+	if clip == nil || clip.Width == 0 || clip.Height == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrElementNotFound, selector)
+	}
+	clip.Scale = 1
+	return clip, nil
+}
@@ -0,0 +1,125 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+var ErrInvalidPDFOptions = errors.New("invalid pdf options")
+
+// paperSizes maps named paper sizes to their width and height in inches.
+var paperSizes = map[string][2]float64{
+	"letter":  {8.5, 11},
+	"legal":   {8.5, 14},
+	"tabloid": {11, 17},
+	"a3":      {11.69, 16.54},
+	"a4":      {8.27, 11.69},
+	"a5":      {5.83, 8.27},
+}
+
+// defaultPDFMargin is Chrome's default margin of 1cm in inches. printToPDF
+// always sends every margin, so unset margins are filled in with it.
+const defaultPDFMargin = 1 / 2.54
+
+// PDFOptions controls PDF output. Sizes and margins are in inches; a zero size
+// or unset margin uses Chrome's defaults (Letter paper, ~0.4in margins).
+type PDFOptions struct {
+	// PaperSize is a named size (letter, legal, tabloid, a3, a4, a5). It is
+	// ignored when PaperWidth and PaperHeight are set.
+	PaperSize   string  `json:"paper_size,omitempty"`
+	PaperWidth  float64 `json:"paper_width,omitempty"`
+	PaperHeight float64 `json:"paper_height,omitempty"`
+	// Margins are pointers so that a zero margin can be requested.
+	MarginTop       *float64 `json:"margin_top,omitempty"`
+	MarginRight     *float64 `json:"margin_right,omitempty"`
+	MarginBottom    *float64 `json:"margin_bottom,omitempty"`
+	MarginLeft      *float64 `json:"margin_left,omitempty"`
+	Landscape       bool     `json:"landscape,omitempty"`
+	PrintBackground bool     `json:"print_background,omitempty"`
+	// HeaderTemplate and FooterTemplate are HTML templates as described for
+	// Page.printToPDF; setting either one enables headers and footers.
+	HeaderTemplate string `json:"header_template,omitempty"`
+	FooterTemplate string `json:"footer_template,omitempty"`
+}
+
+func (o PDFOptions) validate() error {
+	if o.PaperSize != "" {
+		if _, ok := paperSizes[strings.ToLower(o.PaperSize)]; !ok {
+			return fmt.Errorf("%w: unknown paper size %q", ErrInvalidPDFOptions, o.PaperSize)
+		}
+	}
+	if o.PaperWidth < 0 || o.PaperHeight < 0 {
+		return fmt.Errorf("%w: paper size must not be negative", ErrInvalidPDFOptions)
+	}
+	for _, m := range []*float64{o.MarginTop, o.MarginRight, o.MarginBottom, o.MarginLeft} {
+		if m != nil && *m < 0 {
+			return fmt.Errorf("%w: margins must not be negative", ErrInvalidPDFOptions)
+		}
+	}
+	return nil
+}
+
+// margin returns the margin if set and Chrome's default otherwise.
+func margin(m *float64) float64 {
+	if m == nil {
+		return defaultPDFMargin
+	}
+	return *m
+}
+
+// printToPDF prints the current page with Page.printToPDF.
+func printToPDF(ctx context.Context, opts PDFOptions) ([]byte, error) {
+	params := pdfParams(opts)
+	var buf []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		buf, _, err = params.Do(ctx)
+		return err
+	}))
+	return buf, err
+}
+
+// pdfParams maps opts to Page.printToPDF parameters.
+func pdfParams(opts PDFOptions) *page.PrintToPDFParams {
+	params := page.PrintToPDF().
+		WithLandscape(opts.Landscape).
+		WithPrintBackground(opts.PrintBackground)
+
+	width, height := opts.PaperWidth, opts.PaperHeight
+	if size, ok := paperSizes[strings.ToLower(opts.PaperSize)]; ok && (width == 0 || height == 0) {
+		width, height = size[0], size[1]
+	}
+	if width > 0 {
+		params = params.WithPaperWidth(width)
+	}
+	if height > 0 {
+		params = params.WithPaperHeight(height)
+	}
+
+	params = params.
+		WithMarginTop(margin(opts.MarginTop)).
+		WithMarginRight(margin(opts.MarginRight)).
+		WithMarginBottom(margin(opts.MarginBottom)).
+		WithMarginLeft(margin(opts.MarginLeft))
+
+	if opts.HeaderTemplate != "" || opts.FooterTemplate != "" {
+		// Chrome prints its default header or footer for an empty template
+		header, footer := opts.HeaderTemplate, opts.FooterTemplate
+		if header == "" {
+			header = "<span></span>"
+		}
+		if footer == "" {
+			footer = "<span></span>"
+		}
+		params = params.
+			WithDisplayHeaderFooter(true).
+			WithHeaderTemplate(header).
+			WithFooterTemplate(footer)
+	}
+	return params
+}
@@ -3,13 +3,20 @@ package prerender
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"time"
 
+	"github.com/chromedp/cdproto/performance"
 	"github.com/chromedp/chromedp"
 
 	"github.com/IncorrectM/precrawl/internal/browser"
 )
 
+// resetTimeout bounds the cleanup run on a page before it is released.
+const resetTimeout = 2 * time.Second
+
 var (
 	ErrInvalidPool         = errors.New("invalid browser pool")
 	ErrEmptyURL            = errors.New("target url is empty")
@@ -18,7 +25,135 @@ var (
 	ErrWaitTimeout         = errors.New("wait timeout exceeded")
 )
 
-// Render navigates to a URL, waits, and returns the full HTML document.
+// Options tunes a render beyond the selector wait. The zero value renders
+// HTML without resource limits.
+type Options struct {
+	Format     Format
+	Screenshot ScreenshotOptions
+	PDF        PDFOptions
+	// Device emulates a viewport and user agent; nil keeps Chrome's defaults.
+	Device *Device
+	// Locale overrides the ICU locale and, unless AcceptLanguage is set, the
+	// Accept-Language header and navigator.language.
+	Locale         string
+	AcceptLanguage string
+	// Timezone is an IANA timezone ID such as "Europe/Berlin".
+	Timezone    string
+	Geolocation *Geolocation
+	// Headers are sent with the requests the page makes to the origin of
+	// the target URL; requests to other origins go out without them.
+	Headers map[string]string
+	Cookies []Cookie
+	// Scripts run in every document before the page's own scripts.
+	Scripts []string
+	// AutoScroll scrolls through the page after the selector wait to trigger
+	// lazy loading, then waits for the selector again; nil disables it.
+	AutoScroll *AutoScroll
+	// Actions run after the selector wait and before capture.
+	Actions []Action
+	// ShadowDOM serializes open shadow roots into HTML output as declarative
+	// shadow DOM templates.
+	ShadowDOM bool
+	// InlineFrames inlines same-origin iframe documents into HTML output;
+	// nil disables it.
+	InlineFrames *FrameInlining
+	// WaitResponse, if set, waits after navigation for a matching network
+	// response to finish loading. On timeout the render still returns its
+	// output along with ErrResponseTimeout.
+	WaitResponse *ResponseWait
+	// DisableJavaScript renders the page without running its scripts, as a
+	// crawler that does not execute JavaScript would see it. The renderer's
+	// own evaluations, such as the selector wait and actions, still run.
+	DisableJavaScript bool
+	// RecordHAR records the page's network activity into Result.HAR. Cookie,
+	// Authorization and the headers in Headers are left out of it.
+	RecordHAR bool
+	Limits    ResourceLimits
+	// Defaults holds server-wide settings that only a browser can honor.
+	// Browser renders apply them; the http renderer ignores them.
+	Defaults BrowserDefaults
+}
+
+// Validate reports the first invalid option.
+func (o Options) Validate() error {
+	if _, err := ParseFormat(string(o.Format)); err != nil {
+		return err
+	}
+	if err := o.Screenshot.validate(); err != nil {
+		return err
+	}
+	if err := o.validateEmulation(); err != nil {
+		return err
+	}
+	if err := o.validateNetwork(); err != nil {
+		return err
+	}
+	if o.AutoScroll != nil {
+		if err := o.AutoScroll.validate(); err != nil {
+			return err
+		}
+	}
+	if o.InlineFrames != nil {
+		if err := o.InlineFrames.validate(); err != nil {
+			return err
+		}
+	}
+	if o.WaitResponse != nil {
+		if err := o.WaitResponse.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, action := range o.Actions {
+		if err := action.Validate(); err != nil {
+			return fmt.Errorf("action %d: %w", i, err)
+		}
+	}
+	return o.PDF.validate()
+}
+
+// Request describes a single render.
+type Request struct {
+	TargetURL     string
+	Wait          time.Duration
+	QuerySelector string
+	WaitTimeout   time.Duration
+	Options
+}
+
+// normalize validates req and fills in the default format.
+func (req *Request) normalize() error {
+	if req.TargetURL == "" {
+		return ErrEmptyURL
+	}
+	if req.Wait < 0 {
+		return ErrNegativeWait
+	}
+	if req.WaitTimeout < 0 {
+		return ErrNegativeWaitTimeout
+	}
+	if req.Format == "" {
+		req.Format = FormatHTML
+	}
+	return req.Options.Validate()
+}
+
+// Result holds the outcome of a render. HTML is set for FormatHTML, Data for
+// binary formats.
+type Result struct {
+	HTML        string
+	Data        []byte
+	ContentType string
+	Metrics     PageMetrics
+	// Diagnostics and HAR (if recorded) are set even when the render fails.
+	Diagnostics Diagnostics
+	HAR         *HAR
+	// Timings is set even when the render fails, up to the failing phase.
+	Timings Timings
+	// Renderer names the renderer that produced the result.
+	Renderer string
+}
+
+// RenderUntil navigates to a URL, waits for querySelector, and returns the full HTML document.
 func RenderUntil(
 	ctx context.Context,
 	pool *browser.Pool,
@@ -27,87 +162,308 @@ func RenderUntil(
 	querySelector string,
 	waitTimeout time.Duration,
 ) (html string, err error) {
+	result, err := Do(ctx, pool, Request{
+		TargetURL:     targetURL,
+		Wait:          wait,
+		QuerySelector: querySelector,
+		WaitTimeout:   waitTimeout,
+	})
+	return result.HTML, err
+}
+
+// Do performs the render described by req. On ErrWaitTimeout the result still
+// holds the HTML captured after the timeout.
+func Do(ctx context.Context, pool *browser.Pool, req Request) (result Result, err error) {
 	// validate inputs
 	if pool == nil {
-		return "", ErrInvalidPool
-	}
-	if targetURL == "" {
-		return "", ErrEmptyURL
-	}
-	if wait < 0 {
-		return "", ErrNegativeWait
+		return Result{}, ErrInvalidPool
 	}
-	if waitTimeout < 0 {
-		return "", ErrNegativeWaitTimeout
+	if err := req.normalize(); err != nil {
+		return Result{}, err
 	}
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
+	var timings Timings
+	defer func() {
+		result.Timings = timings
+	}()
+
 	// acquire a browser page from the pool
-	page, err := pool.AcquireBlank(ctx)
+	var page *browser.Page
+	err = measure(&timings.Acquire, func() error {
+		page, err = pool.AcquireBlank(ctx)
+		return err
+	})
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
+	// resetErr collects failed resets of the per-render state below
+	var resetErr error
 	defer func() {
-		releaseErr := pool.Release(page)
+		// a page that hit a resource limit may still be busy, and one that
+		// could not be reset would leak its state into the next render;
+		// replace both
+		var releaseErr error
+		if errors.Is(err, ErrResourceLimit) || resetErr != nil {
+			releaseErr = pool.Recycle(page)
+		} else {
+			releaseErr = pool.Release(page)
+		}
 		if err == nil && releaseErr != nil {
 			err = releaseErr
 		}
 	}()
 
-	runCtx, cancel := context.WithCancel(page.Ctx)
-	defer cancel()
+	runCtx, cancel := context.WithCancelCause(page.Ctx)
+	defer cancel(nil)
 
 	go func() {
 		select {
 		// gracefully handle context cancellation to ensure the browser page is released
 		case <-ctx.Done():
-			cancel()
+			cancel(ctx.Err())
 		case <-runCtx.Done():
 		}
 	}()
 
-	if err := chromedp.Run(
-		runCtx,
-		chromedp.Navigate(targetURL),
-		chromedp.WaitReady("body", chromedp.ByQuery), // ensure DOM is ready
-	); err != nil {
-		return "", err
+	// collect console messages, exceptions and failed requests for this render
+	recorder := newDiagnosticsRecorder()
+	chromedp.ListenTarget(runCtx, recorder.record)
+	defer func() {
+		result.Diagnostics = recorder.snapshot()
+	}()
+	if req.RecordHAR {
+		har := newHARRecorder(req.TargetURL, slices.Collect(maps.Keys(req.Headers)))
+		chromedp.ListenTarget(runCtx, har.record)
+		defer func() {
+			result.HAR = har.har()
+		}()
+	}
+	// listen before navigating so early responses are not missed
+	var responses *responseWaiter
+	if req.WaitResponse != nil {
+		responses = newResponseWaiter(*req.WaitResponse)
+		chromedp.ListenTarget(runCtx, responses.record)
+	}
+
+	// watch page metrics so runaway pages are aborted
+	if err := chromedp.Run(runCtx, performance.Enable()); err != nil {
+		return Result{}, err
+	}
+	if req.Limits.enabled() {
+		go monitor(runCtx, req.Limits, cancel)
+	}
+
+	// emulate before navigating so the page sees the overrides from the start
+	if req.emulates() {
+		defer func() {
+			if !errors.Is(err, ErrResourceLimit) {
+				resetErr = errors.Join(resetErr, resetPage(page.Ctx, func(ctx context.Context) error { return resetEmulation(ctx, req.Options) }))
+			}
+		}()
+		if err := emulate(runCtx, req.Options); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if len(req.Headers) > 0 || len(req.Cookies) > 0 {
+		defer func() {
+			if !errors.Is(err, ErrResourceLimit) {
+				resetErr = errors.Join(resetErr, resetPage(page.Ctx, resetNetwork))
+			}
+		}()
+		if err := applyNetwork(runCtx, req.TargetURL, req.Options); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if len(req.Scripts) > 0 {
+		ids, injectErr := injectScripts(runCtx, req.Scripts)
+		defer func() {
+			if !errors.Is(err, ErrResourceLimit) {
+				resetErr = errors.Join(resetErr, resetPage(page.Ctx, func(ctx context.Context) error { return removeScripts(ctx, ids) }))
+			}
+		}()
+		if injectErr != nil {
+			return Result{}, injectErr
+		}
 	}
 
+	result, err = capture(runCtx, req, responses, &timings)
+	var limitErr *ResourceLimitError
+	if errors.As(context.Cause(runCtx), &limitErr) {
+		return Result{Metrics: limitErr.Metrics}, limitErr
+	}
+	if err != nil && !errors.Is(err, ErrWaitTimeout) {
+		return Result{}, err
+	}
+
+	result.Metrics, _ = collectMetrics(runCtx)
+	if err := req.Limits.check(result.Metrics); err != nil {
+		return Result{Metrics: result.Metrics}, err
+	}
+	return result, err
+}
+
+// resetPage undoes per-render page state before the page goes back to the
+// pool. An error, including no response within resetTimeout, means the page
+// may still carry the state and must not be reused.
+func resetPage(pageCtx context.Context, reset func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(pageCtx, resetTimeout)
+	defer cancel()
+	return reset(ctx)
+}
+
+// runEach runs every action, even after one fails, and joins the errors.
+func runEach(ctx context.Context, actions ...chromedp.Action) error {
+	var errs []error
+	for _, action := range actions {
+		errs = append(errs, chromedp.Run(ctx, action))
+	}
+	return errors.Join(errs...)
+}
+
+// capture navigates to the target, runs the response wait, the selector wait
+// and the sleep, and produces the requested output. It returns
+// ErrResponseTimeout or ErrWaitTimeout alongside the output when the response
+// did not arrive or the selector did not become visible in time. responses is
+// nil without a response wait.
+func capture(runCtx context.Context, req Request, responses *responseWaiter, timings *Timings) (Result, error) {
+	responseTimedOut, waitTimedOut, err := navigateAndWait(runCtx, req, responses, timings)
+	if err != nil {
+		return Result{}, err
+	}
+	if req.AutoScroll != nil {
+		err := measure(&timings.AutoScroll, func() error {
+			if err := autoScroll(runCtx, *req.AutoScroll); err != nil {
+				return err
+			}
+			// content loaded while scrolling may have replaced the selector
+			var err error
+			waitTimedOut, err = waitSelector(runCtx, req)
+			return err
+		})
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	if err := measure(&timings.Actions, func() error { return runActions(runCtx, req.Actions) }); err != nil {
+		return Result{}, err
+	}
+	// web vitals are best effort and never fail the render
+	_ = collectVitals(runCtx, timings)
+
+	captureStart := time.Now()
+	defer func() {
+		timings.Capture = time.Since(captureStart)
+	}()
+	// frames are tagged before the capture so the captured HTML carries the tags
+	var frames map[string]string
+	if req.InlineFrames != nil && !req.Format.Binary() {
+		if frames, err = frameDocuments(runCtx, *req.InlineFrames); err != nil {
+			return Result{}, err
+		}
+	}
+	result := Result{ContentType: req.Format.ContentType()}
+	switch {
+	case req.Format.image():
+		result.Data, err = screenshot(runCtx, req.Format, req.Screenshot)
+	case req.Format == FormatPDF:
+		result.Data, err = printToPDF(runCtx, req.PDF)
+	case req.Format == FormatMHTML:
+		result.Data, err = captureMHTML(runCtx)
+	case req.Format == FormatSingleFile:
+		var archive []byte
+		if archive, err = captureMHTML(runCtx); err == nil {
+			result.Data, err = SingleFileHTML(archive)
+		}
+	case req.ShadowDOM:
+		result.HTML, err = shadowHTML(runCtx)
+	default:
+		err = chromedp.Run(runCtx, chromedp.OuterHTML("html", &result.HTML, chromedp.ByQuery))
+	}
+	if err == nil && len(frames) > 0 {
+		result.HTML, err = InlineFrames(result.HTML, frames, req.InlineFrames.MaxBytes)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	if responseTimedOut {
+		return result, ErrResponseTimeout
+	}
+	if waitTimedOut {
+		return result, ErrWaitTimeout
+	}
+
+	return result, nil
+}
+
+// navigateAndWait navigates to the target, waits for the selector to become
+// visible and then sleeps for req.Wait. It reports whether the selector wait
+// timed out.
+func navigateAndWait(runCtx context.Context, req Request, responses *responseWaiter, timings *Timings) (responseTimedOut, waitTimedOut bool, err error) {
+	if err := measure(&timings.Navigation, func() error {
+		return chromedp.Run(
+			runCtx,
+			chromedp.Navigate(req.TargetURL),
+			chromedp.WaitReady("body", chromedp.ByQuery), // ensure DOM is ready
+		)
+	}); err != nil {
+		return false, false, err
+	}
+
+	if responses != nil {
+		timeout := req.WaitResponse.Timeout
+		if timeout == 0 {
+			timeout = req.WaitTimeout
+		}
+		if err := measure(&timings.ResponseWait, func() error {
+			responseTimedOut, err = responses.waitFor(runCtx, timeout)
+			return err
+		}); err != nil {
+			return false, false, err
+		}
+	}
+
+	if err := measure(&timings.SelectorWait, func() error {
+		waitTimedOut, err = waitSelector(runCtx, req)
+		return err
+	}); err != nil {
+		return false, false, err
+	}
+
+	// ensure any additional content has time to load
+	if err := measure(&timings.Sleep, func() error { return chromedp.Run(runCtx, chromedp.Sleep(req.Wait)) }); err != nil {
+		return false, false, err
+	}
+
+	return responseTimedOut, waitTimedOut, nil
+}
+
+// waitSelector waits for the selector to become visible within
+// req.WaitTimeout and reports whether that timed out.
+func waitSelector(runCtx context.Context, req Request) (waitTimedOut bool, err error) {
 	// wait for the specified element to become visible
-	waitTimedOut := false
-	if waitTimeout > 0 {
-		waitCtx, waitCancel := context.WithTimeout(runCtx, waitTimeout)
-		waitErr := chromedp.Run(waitCtx, chromedp.WaitVisible(querySelector, chromedp.ByQuery))
+	if req.WaitTimeout > 0 {
+		waitCtx, waitCancel := context.WithTimeout(runCtx, req.WaitTimeout)
+		waitErr := chromedp.Run(waitCtx, chromedp.WaitVisible(req.QuerySelector, chromedp.ByQuery))
 		waitCancel()
 		if waitErr != nil {
 			if errors.Is(waitErr, context.DeadlineExceeded) && errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
 				waitTimedOut = true
 			} else {
-				return "", waitErr
+				return false, waitErr
 			}
 		}
 	} else {
-		if err := chromedp.Run(runCtx, chromedp.WaitVisible(querySelector, chromedp.ByQuery)); err != nil {
-			return "", err
+		if err := chromedp.Run(runCtx, chromedp.WaitVisible(req.QuerySelector, chromedp.ByQuery)); err != nil {
+			return false, err
 		}
 	}
-
-	if err := chromedp.Run(
-		runCtx,
-		chromedp.Sleep(wait), // ensure any additional content has time to load
-		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
-	); err != nil {
-		return "", err
-	}
-
-	if waitTimedOut {
-		return html, ErrWaitTimeout
-	}
-
-	return html, nil
+	return waitTimedOut, nil
 }
 
 func Render(
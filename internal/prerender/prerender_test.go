@@ -2,10 +2,21 @@ package prerender
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"golang.org/x/net/html"
+
 	"github.com/IncorrectM/precrawl/internal/browser"
 )
 
@@ -72,7 +83,13 @@ func TestRenderSuccess(t *testing.T) {
 	}
 	defer pool.Close()
 
-	html, err := Render(context.Background(), pool, "https://example.com", 100*time.Millisecond)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>Local</title></head><body><p>rendered</p></body></html>"))
+	}))
+	defer site.Close()
+
+	html, err := Render(context.Background(), pool, site.URL, 100*time.Millisecond)
 	if err != nil {
 		t.Fatalf("Render error: %v", err)
 	}
@@ -82,3 +99,660 @@ func TestRenderSuccess(t *testing.T) {
 		t.Logf("Rendered HTML length: %d", len(html))
 	}
 }
+
+func TestResourceLimitsCheck(t *testing.T) {
+	t.Parallel()
+
+	limits := ResourceLimits{MaxJSHeapBytes: 1 << 20, MaxDOMNodes: 100, MaxCPUTime: time.Second}
+
+	if err := limits.check(PageMetrics{JSHeapUsedBytes: 1 << 10, DOMNodes: 10, CPUTime: time.Millisecond}); err != nil {
+		t.Fatalf("expected metrics within limits, got %v", err)
+	}
+
+	err := limits.check(PageMetrics{DOMNodes: 101})
+	if !errors.Is(err, ErrResourceLimit) {
+		t.Fatalf("expected ErrResourceLimit, got %v", err)
+	}
+	var limitErr *ResourceLimitError
+	if !errors.As(err, &limitErr) || limitErr.Resource != "domNodes" {
+		t.Fatalf("expected domNodes limit error, got %v", err)
+	}
+
+	if err := (ResourceLimits{}).check(PageMetrics{JSHeapUsedBytes: 1 << 40}); err != nil {
+		t.Fatalf("expected zero limits to be disabled, got %v", err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]Format{"": FormatHTML, "html": FormatHTML, "png": FormatPNG, "jpg": FormatJPEG, "webp": FormatWebP}
+	for name, want := range cases {
+		got, err := ParseFormat(name)
+		if err != nil {
+			t.Fatalf("ParseFormat(%q) error: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("ParseFormat(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err := ParseFormat("gif"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+	if got := FormatWebP.ContentType(); got != "image/webp" {
+		t.Fatalf("unexpected content type %q", got)
+	}
+}
+
+func TestRenderInvalidScreenshotQuality(t *testing.T) {
+	t.Parallel()
+
+	pool, err := browser.NewPool(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	defer pool.Close()
+
+	_, err = Do(context.Background(), pool, Request{
+		TargetURL:     "https://example.com",
+		QuerySelector: "body",
+		Options:       Options{Format: FormatJPEG, Screenshot: ScreenshotOptions{Quality: 101}},
+	})
+	if !errors.Is(err, ErrInvalidQuality) {
+		t.Fatalf("expected ErrInvalidQuality, got %v", err)
+	}
+}
+
+func TestPDFOptionsValidation(t *testing.T) {
+	t.Parallel()
+
+	top, negative := 0.5, -1.0
+	valid := Options{Format: FormatPDF, PDF: PDFOptions{PaperSize: "A4", MarginTop: &top, Landscape: true}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid options, got %v", err)
+	}
+
+	if err := (Options{Format: FormatPDF, PDF: PDFOptions{PaperSize: "postcard"}}).Validate(); !errors.Is(err, ErrInvalidPDFOptions) {
+		t.Fatalf("expected ErrInvalidPDFOptions for paper size, got %v", err)
+	}
+	if err := (Options{Format: FormatPDF, PDF: PDFOptions{MarginLeft: &negative}}).Validate(); !errors.Is(err, ErrInvalidPDFOptions) {
+		t.Fatalf("expected ErrInvalidPDFOptions for margin, got %v", err)
+	}
+}
+
+func TestPDFParamsMargins(t *testing.T) {
+	t.Parallel()
+
+	top, left := 0.0, 1.5
+	params := pdfParams(PDFOptions{MarginTop: &top, MarginLeft: &left})
+	if params.MarginTop != 0 || params.MarginLeft != 1.5 {
+		t.Fatalf("expected set margins to be passed, got top=%v left=%v", params.MarginTop, params.MarginLeft)
+	}
+	if params.MarginRight != defaultPDFMargin || params.MarginBottom != defaultPDFMargin {
+		t.Fatalf("expected unset margins to use the default, got right=%v bottom=%v", params.MarginRight, params.MarginBottom)
+	}
+
+	var opts PDFOptions
+	if err := json.Unmarshal([]byte(`{"margin_bottom":0}`), &opts); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if opts.MarginBottom == nil || *opts.MarginBottom != 0 {
+		t.Fatalf("expected explicit zero margin, got %v", opts.MarginBottom)
+	}
+}
+
+func TestSingleFileHTML(t *testing.T) {
+	t.Parallel()
+
+	archive := strings.Join([]string{
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/related; type="text/html"; boundary="BOUNDARY"`,
+		"",
+		"--BOUNDARY",
+		"Content-Type: text/html",
+		"Content-Location: https://example.com/page",
+		"",
+		`<html><head><link rel="stylesheet" href="/site.css"></head><body><img src="logo.png"></body></html>`,
+		"--BOUNDARY",
+		"Content-Type: text/css",
+		"Content-Location: https://example.com/site.css",
+		"",
+		`body { background: url("bg.png"); }`,
+		"--BOUNDARY",
+		"Content-Type: image/png",
+		"Content-Transfer-Encoding: base64",
+		"Content-Location: https://example.com/logo.png",
+		"",
+		"bG9nbw==",
+		"--BOUNDARY",
+		"Content-Type: image/png",
+		"Content-Transfer-Encoding: base64",
+		"Content-Location: https://example.com/bg.png",
+		"",
+		"Ymc=",
+		"--BOUNDARY--",
+		"",
+	}, "\r\n")
+
+	out, err := SingleFileHTML([]byte(archive))
+	if err != nil {
+		t.Fatalf("SingleFileHTML error: %v", err)
+	}
+
+	html := string(out)
+	if !strings.Contains(html, `<img src="data:image/png;base64,bG9nbw=="/>`) {
+		t.Fatalf("expected inlined image, got %s", html)
+	}
+	if !strings.Contains(html, `<style>body { background: url("data:image/png;base64,Ymc="); }</style>`) {
+		t.Fatalf("expected inlined stylesheet, got %s", html)
+	}
+	if strings.Contains(html, "<link") {
+		t.Fatalf("expected stylesheet link to be replaced, got %s", html)
+	}
+
+	if _, err := SingleFileHTML([]byte("not an archive")); !errors.Is(err, ErrInvalidArchive) {
+		t.Fatalf("expected ErrInvalidArchive, got %v", err)
+	}
+}
+
+func TestDeviceForUserAgent(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": DeviceMobile,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                       DeviceDesktop,
+		"Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1": DeviceTablet,
+	}
+	for ua, want := range cases {
+		if got := DeviceForUserAgent(ua); got != want {
+			t.Fatalf("DeviceForUserAgent(%q) = %q, want %q", ua, got, want)
+		}
+	}
+
+	for name, device := range DefaultDevices() {
+		if err := device.Validate(); err != nil {
+			t.Fatalf("built-in device %s is invalid: %v", name, err)
+		}
+	}
+}
+
+func TestEmulationOptionsValidation(t *testing.T) {
+	t.Parallel()
+
+	valid := Options{Locale: "de-DE", Timezone: "Europe/Berlin", Geolocation: &Geolocation{Latitude: 52.52, Longitude: 13.405}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid options, got %v", err)
+	}
+
+	cases := map[string]struct {
+		options Options
+		err     error
+	}{
+		"locale":      {Options{Locale: "de DE"}, ErrInvalidLocale},
+		"timezone":    {Options{Timezone: "Mars/Olympus"}, ErrInvalidTimezone},
+		"geolocation": {Options{Geolocation: &Geolocation{Latitude: 91}}, ErrInvalidGeolocation},
+	}
+	for name, c := range cases {
+		if err := c.options.Validate(); !errors.Is(err, c.err) {
+			t.Fatalf("%s: expected %v, got %v", name, c.err, err)
+		}
+	}
+}
+
+func TestParseGeolocation(t *testing.T) {
+	t.Parallel()
+
+	geo, err := ParseGeolocation("40.7128, -74.006, 25")
+	if err != nil {
+		t.Fatalf("ParseGeolocation error: %v", err)
+	}
+	if geo.Latitude != 40.7128 || geo.Longitude != -74.006 || geo.Accuracy != 25 {
+		t.Fatalf("unexpected geolocation: %+v", geo)
+	}
+	for _, raw := range []string{"40.7", "a,b", "0,181", "1,2,3,4"} {
+		if _, err := ParseGeolocation(raw); !errors.Is(err, ErrInvalidGeolocation) {
+			t.Fatalf("expected error for %q, got %v", raw, err)
+		}
+	}
+}
+
+func TestNetworkOptionsValidation(t *testing.T) {
+	t.Parallel()
+
+	valid := Options{Headers: map[string]string{"X-Bypass": "token"}, Cookies: []Cookie{{Name: "session", Value: "abc"}}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid options, got %v", err)
+	}
+	if err := (Options{Headers: map[string]string{"Bad Header": "x"}}).Validate(); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+	if err := (Options{Headers: map[string]string{"X-Bypass": "a\r\nb"}}).Validate(); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader for header value, got %v", err)
+	}
+	if err := (Options{Cookies: []Cookie{{Name: "a=b"}}}).Validate(); !errors.Is(err, ErrInvalidCookie) {
+		t.Fatalf("expected ErrInvalidCookie, got %v", err)
+	}
+}
+
+func TestScopedHeaders(t *testing.T) {
+	t.Parallel()
+
+	target, err := url.Parse("https://Example.com:443/page")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	origin := requestOrigin(target)
+	cases := map[string]bool{
+		"https://example.com/api":      true,
+		"https://example.com:443/":     true,
+		"http://example.com/":          false,
+		"https://example.com:8443/":    false,
+		"https://cdn.example.com/x.js": false,
+		"https://example.com.evil/":    false,
+	}
+	for raw, want := range cases {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		if got := requestOrigin(u) == origin; got != want {
+			t.Fatalf("%s: expected same origin %v, got %v", raw, want, got)
+		}
+	}
+
+	entries := withHeaders(network.Headers{"accept": "*/*", "authorization": "Bearer page"}, map[string]string{"Authorization": "Bearer secret"})
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		got = append(got, e.Name+": "+e.Value)
+	}
+	if want := []string{"Authorization: Bearer secret", "accept: */*"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestActionValidation(t *testing.T) {
+	t.Parallel()
+
+	valid := []Action{
+		{Type: ActionClick, Selector: ".load-more"},
+		{Type: ActionTypeText, Selector: "input[name=q]", Text: "shoes"},
+		{Type: ActionScroll, Y: 800},
+		{Type: ActionWaitForSelector, Selector: ".results", TimeoutMs: 5000},
+		{Type: ActionWaitMs, Ms: 250},
+		{Type: ActionEvaluate, Script: "document.querySelector('.modal')?.remove()"},
+	}
+	if err := (Options{Actions: valid}).Validate(); err != nil {
+		t.Fatalf("expected valid actions, got %v", err)
+	}
+
+	invalid := []Action{
+		{Type: ActionClick},
+		{Type: ActionWaitMs},
+		{Type: ActionEvaluate},
+		{Type: "hover", Selector: "a"},
+		{Type: ActionWaitForSelector, Selector: "a", TimeoutMs: -1},
+	}
+	for _, action := range invalid {
+		if err := (Options{Actions: []Action{action}}).Validate(); !errors.Is(err, ErrInvalidAction) {
+			t.Fatalf("expected ErrInvalidAction for %+v, got %v", action, err)
+		}
+	}
+}
+
+func TestDiagnosticsRecorder(t *testing.T) {
+	t.Parallel()
+
+	r := newDiagnosticsRecorder()
+	r.record(&runtime.EventConsoleAPICalled{
+		Type: runtime.APITypeError,
+		Args: []*runtime.RemoteObject{{Type: runtime.TypeString, Value: []byte(`"boom"`)}, {Type: runtime.TypeNumber, Value: []byte("42")}},
+	})
+	r.record(&runtime.EventConsoleAPICalled{Type: runtime.APITypeLog, Args: []*runtime.RemoteObject{{Type: runtime.TypeString, Value: []byte(`"hello"`)}}})
+	r.record(&runtime.EventExceptionThrown{ExceptionDetails: &runtime.ExceptionDetails{
+		Text:       "Uncaught",
+		LineNumber: 3,
+		Exception:  &runtime.RemoteObject{Description: "TypeError: x is undefined"},
+	}})
+	r.record(&network.EventRequestWillBeSent{RequestID: "1", Request: &network.Request{URL: "https://example.com/api", Method: "POST"}})
+	r.record(&network.EventLoadingFailed{RequestID: "1", Type: network.ResourceTypeXHR, ErrorText: "net::ERR_CONNECTION_REFUSED"})
+	r.record(&network.EventResponseReceived{RequestID: "2", Response: &network.Response{URL: "https://example.com/missing.js", Status: 404}})
+	r.record(&network.EventResponseReceived{RequestID: "3", Response: &network.Response{URL: "https://example.com/ok.js", Status: 200}})
+
+	d := r.snapshot()
+	if len(d.Console) != 2 || d.Console[0].Text != "boom 42" || d.ConsoleErrors() != 1 {
+		t.Fatalf("unexpected console messages: %+v", d.Console)
+	}
+	if len(d.Exceptions) != 1 || d.Exceptions[0].Message != "TypeError: x is undefined" {
+		t.Fatalf("unexpected exceptions: %+v", d.Exceptions)
+	}
+	if len(d.FailedRequests) != 2 {
+		t.Fatalf("expected 2 failed requests, got %+v", d.FailedRequests)
+	}
+	if failed := d.FailedRequests[0]; failed.URL != "https://example.com/api" || failed.Method != "POST" || failed.Error == "" {
+		t.Fatalf("unexpected failed request: %+v", failed)
+	}
+	if failed := d.FailedRequests[1]; failed.Status != 404 {
+		t.Fatalf("unexpected failed request: %+v", failed)
+	}
+
+	for range maxDiagnostics {
+		r.record(&runtime.EventConsoleAPICalled{Type: runtime.APITypeLog})
+	}
+	if d := r.snapshot(); len(d.Console) != maxDiagnostics || d.Dropped != 2 {
+		t.Fatalf("expected console capped at %d with 2 dropped, got %d and %d", maxDiagnostics, len(d.Console), d.Dropped)
+	}
+}
+
+func TestHARRecorder(t *testing.T) {
+	t.Parallel()
+
+	at := func(seconds float64) *cdp.MonotonicTime {
+		ts := cdp.MonotonicTime(cdp.MonotonicTimeEpoch.Add(time.Duration(seconds * float64(time.Second))))
+		return &ts
+	}
+
+	r := newHARRecorder("https://example.com/", []string{"x-bypass"})
+	r.record(&network.EventRequestWillBeSent{
+		RequestID: "1",
+		Timestamp: at(10),
+		Request: &network.Request{URL: "http://example.com/?a=1", Method: "GET", Headers: network.Headers{
+			"Accept":        "text/html",
+			"Authorization": "Bearer secret",
+			"Cookie":        "session=secret",
+			"X-Bypass":      "secret",
+		}},
+	})
+	// redirect to https reuses the request id
+	r.record(&network.EventRequestWillBeSent{
+		RequestID:        "1",
+		Timestamp:        at(10.05),
+		Request:          &network.Request{URL: "https://example.com/?a=1", Method: "GET"},
+		RedirectResponse: &network.Response{Status: 301, StatusText: "Moved Permanently"},
+	})
+	r.record(&network.EventResponseReceived{RequestID: "1", Response: &network.Response{
+		Status:         200,
+		MimeType:       "text/html",
+		Protocol:       "h2",
+		Headers:        network.Headers{"Content-Type": "text/html", "Set-Cookie": "session=new"},
+		RequestHeaders: network.Headers{"Accept": "text/html", "Cookie": "session=secret"},
+	}})
+	r.record(&network.EventLoadingFinished{RequestID: "1", Timestamp: at(10.25), EncodedDataLength: 1024})
+	r.record(&network.EventRequestWillBeSent{RequestID: "2", Timestamp: at(10.3), Request: &network.Request{URL: "https://example.com/app.js", Method: "GET"}})
+	r.record(&network.EventLoadingFailed{RequestID: "2", Timestamp: at(10.4), ErrorText: "net::ERR_FAILED"})
+
+	har := r.har()
+	if har.Log.Version != "1.2" || len(har.Log.Pages) != 1 {
+		t.Fatalf("unexpected log header: %+v", har.Log)
+	}
+	entries := har.Log.Entries
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if redirect := entries[0]; redirect.Response.Status != 301 || redirect.Response.RedirectURL != "https://example.com/?a=1" || redirect.Time < 49 || redirect.Time > 51 {
+		t.Fatalf("unexpected redirect entry: %+v", redirect)
+	}
+	if len(entries[0].Request.QueryString) != 1 || entries[0].Request.QueryString[0].Name != "a" {
+		t.Fatalf("unexpected query string: %+v", entries[0].Request.QueryString)
+	}
+	if final := entries[1]; final.Response.Status != 200 || final.Response.HTTPVersion != "HTTP/2.0" || final.Response.BodySize != 1024 || final.Time < 199 || final.Time > 201 {
+		t.Fatalf("unexpected final entry: %+v", final)
+	}
+	if headers := entries[0].Request.Headers; len(headers) != 1 || headers[0].Name != "Accept" {
+		t.Fatalf("expected credentials and injected headers to be left out, got %+v", headers)
+	}
+	final := entries[1]
+	if len(final.Request.Headers) != 1 || len(final.Response.Headers) != 1 || final.Response.Headers[0].Name != "Content-Type" {
+		t.Fatalf("expected cookies to be left out, got %+v and %+v", final.Request.Headers, final.Response.Headers)
+	}
+	if failed := entries[2]; failed.Error != "net::ERR_FAILED" {
+		t.Fatalf("unexpected failed entry: %+v", failed)
+	}
+}
+
+func TestInlineFrames(t *testing.T) {
+	t.Parallel()
+
+	// the live DOM can differ from the parsed tree, e.g. an iframe inside
+	// <noscript> parses as text here, so frames are matched by tag only
+	document := `<html><head><noscript><iframe src="/tracker"></iframe></noscript></head><body>` +
+		`<iframe src="https://other.example/"></iframe>` +
+		`<template shadowrootmode="open"><iframe src="/shadow" data-precrawl-frame="B"></iframe></template>` +
+		`<iframe src="/widget" data-precrawl-frame="A"></iframe>` +
+		`<iframe src="/large" data-precrawl-frame="C"></iframe>` +
+		`<iframe src="/unreadable" data-precrawl-frame="D"></iframe></body></html>`
+	frames := map[string]string{
+		"A": `<!DOCTYPE html><html><body><p>widget & "quotes"</p><iframe data-precrawl-frame="E"></iframe></body></html>`,
+		"B": "<html><body>shadow</body></html>",
+		"C": "<html><body>" + strings.Repeat("x", 200) + "</body></html>",
+		"E": "<html><body>nested</body></html>",
+	}
+	out, err := InlineFrames(document, frames, 200)
+	if err != nil {
+		t.Fatalf("InlineFrames error: %v", err)
+	}
+	if !strings.Contains(out, `<iframe src="/widget" srcdoc="&lt;!DOCTYPE html&gt;&lt;html&gt;&lt;head&gt;&lt;/head&gt;&lt;body&gt;&lt;p&gt;widget &amp;amp; &amp;#34;quotes&amp;#34;&lt;/p&gt;&lt;iframe srcdoc=&#34;&amp;lt;html&amp;gt;&amp;lt;head&amp;gt;&amp;lt;/head&amp;gt;&amp;lt;body&amp;gt;nested`) {
+		t.Fatalf("expected the tagged iframe to be inlined with its nested frame, got %s", out)
+	}
+	if !strings.Contains(out, `<iframe src="/shadow" srcdoc="&lt;html&gt;&lt;head&gt;&lt;/head&gt;&lt;body&gt;shadow`) {
+		t.Fatalf("expected the iframe in the shadow root to be inlined, got %s", out)
+	}
+	for _, untouched := range []string{`<iframe src="https://other.example/"></iframe>`, `<iframe src="/large"></iframe>`, `<iframe src="/unreadable"></iframe>`} {
+		if !strings.Contains(out, untouched) {
+			t.Fatalf("expected %s, got %s", untouched, out)
+		}
+	}
+	if strings.Contains(out, frameAttr) || strings.Count(out, "srcdoc") != 3 {
+		t.Fatalf("expected the tags to be removed and three frames inlined, got %s", out)
+	}
+}
+
+func TestMatchSelector(t *testing.T) {
+	t.Parallel()
+
+	doc, err := html.Parse(strings.NewReader(`<main id="app" class="page ready"><ul><li data-id="7">a</li></ul></main>`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	cases := map[string]bool{
+		"body":                   true,
+		"#app":                   true,
+		"main.page.ready":        true,
+		"main.loading":           false,
+		"#app li[data-id]":       true,
+		"li[data-id='7']":        true,
+		`li[data-id="8"]`:        false,
+		"ul main":                false,
+		"#missing, main li":      true,
+		"*[data-id]":             true,
+		"section":                false,
+		"   main   ul   li   ":   true,
+		"#app >  li":             false,
+		"li:first-child":         false,
+		"li[data-id^=7]":         false,
+		"main.page#app ul > li ": false,
+	}
+	for selector, want := range cases {
+		if got, _ := matchSelector(doc, selector); got != want {
+			t.Fatalf("selector %q: expected %v, got %v", selector, want, got)
+		}
+	}
+	if _, err := matchSelector(doc, "li:first-child"); !errors.Is(err, ErrUnsupportedSelector) {
+		t.Fatalf("expected ErrUnsupportedSelector, got %v", err)
+	}
+}
+
+// fakeRenderer records whether it was used.
+type fakeRenderer struct {
+	called bool
+}
+
+func (r *fakeRenderer) Render(ctx context.Context, req Request) (Result, error) {
+	r.called = true
+	return Result{HTML: "<html>browser</html>", Renderer: RendererChrome}, nil
+}
+
+func TestHTTPRenderer(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/static":
+			if r.Header.Get("X-Token") != "secret" || r.Header.Get("Accept-Language") != "de-DE" {
+				http.Error(w, "missing headers", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><body><div id="content">hello</div></body></html>`))
+		case "/spa":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><body><div id="root"></div></body></html>`))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	renderer := &HTTPRenderer{Client: server.Client()}
+	req := Request{
+		TargetURL:     server.URL + "/static",
+		QuerySelector: "#content",
+		Options:       Options{Locale: "de-DE", Headers: map[string]string{"X-Token": "secret"}},
+	}
+	result, err := renderer.Render(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if !strings.Contains(result.HTML, "hello") || result.Renderer != RendererHTTP || result.ContentType != FormatHTML.ContentType() {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	req.TargetURL = server.URL + "/spa"
+	if result, err = renderer.Render(context.Background(), req); !errors.Is(err, ErrWaitTimeout) || !strings.Contains(result.HTML, "root") {
+		t.Fatalf("expected ErrWaitTimeout with the document, got %+v, %v", result, err)
+	}
+
+	req.TargetURL = server.URL + "/json"
+	if _, err = renderer.Render(context.Background(), req); !errors.Is(err, ErrNotHTML) {
+		t.Fatalf("expected ErrNotHTML, got %v", err)
+	}
+
+	req.Format = FormatPNG
+	if _, err = renderer.Render(context.Background(), req); !errors.Is(err, ErrBrowserRequired) {
+		t.Fatalf("expected ErrBrowserRequired, got %v", err)
+	}
+
+	// auto serves pages that already contain the selector without the browser
+	req.Format = FormatHTML
+	browserRenderer := &fakeRenderer{}
+	auto := &AutoRenderer{HTTP: renderer, Browser: browserRenderer}
+	if result, err = auto.Render(context.Background(), Request{TargetURL: server.URL + "/static", QuerySelector: "#content", Options: req.Options}); err != nil || result.Renderer != RendererHTTP || browserRenderer.called {
+		t.Fatalf("expected http result, got %+v, %v", result, err)
+	}
+	if result, err = auto.Render(context.Background(), Request{TargetURL: server.URL + "/spa", QuerySelector: "#content"}); err != nil || result.Renderer != RendererChrome || !browserRenderer.called {
+		t.Fatalf("expected browser fallback, got %+v, %v", result, err)
+	}
+
+	// options that only a page can honor are rejected, and auto renders them
+	// in the browser even though the document already has the selector
+	browserOnly := map[string]func(*Options){
+		"timezone":    func(o *Options) { o.Timezone = "Europe/Berlin" },
+		"geolocation": func(o *Options) { o.Geolocation = &Geolocation{Latitude: 52.52, Longitude: 13.4} },
+		"har":         func(o *Options) { o.RecordHAR = true },
+		"limits":      func(o *Options) { o.Limits = ResourceLimits{MaxDOMNodes: 1000} },
+		"device":      func(o *Options) { m := DefaultDevices()[DeviceMobile]; o.Device = &m },
+	}
+	for name, set := range browserOnly {
+		browserReq := Request{TargetURL: server.URL + "/static", QuerySelector: "#content", Options: req.Options}
+		set(&browserReq.Options)
+		if _, err = renderer.Render(context.Background(), browserReq); !errors.Is(err, ErrBrowserRequired) {
+			t.Fatalf("%s: expected ErrBrowserRequired, got %v", name, err)
+		}
+		browserRenderer.called = false
+		if result, err = auto.Render(context.Background(), browserReq); err != nil || result.Renderer != RendererChrome || !browserRenderer.called {
+			t.Fatalf("%s: expected browser fallback, got %+v, %v", name, result, err)
+		}
+	}
+
+	// the same settings as browser defaults do not keep http from fetching
+	mobile := DefaultDevices()[DeviceMobile]
+	withDefaults := Request{TargetURL: server.URL + "/static", QuerySelector: "#content", Options: req.Options}
+	withDefaults.Defaults = BrowserDefaults{Device: &mobile, Timezone: "Europe/Berlin", RecordHAR: true, Limits: ResourceLimits{MaxDOMNodes: 1000}}
+	browserRenderer.called = false
+	if result, err = auto.Render(context.Background(), withDefaults); err != nil || result.Renderer != RendererHTTP || browserRenderer.called {
+		t.Fatalf("expected http result with browser defaults, got %+v, %v", result, err)
+	}
+}
+
+func TestParseRenderer(t *testing.T) {
+	t.Parallel()
+
+	for input, want := range map[string]string{"": RendererChrome, "HTTP": RendererHTTP, " auto ": RendererAuto} {
+		if got, err := ParseRenderer(input); err != nil || got != want {
+			t.Fatalf("ParseRenderer(%q): expected %q, got %q, %v", input, want, got, err)
+		}
+	}
+	if _, err := ParseRenderer("webkit"); !errors.Is(err, ErrUnknownRenderer) {
+		t.Fatalf("expected ErrUnknownRenderer, got %v", err)
+	}
+}
+
+func TestParseResponseWait(t *testing.T) {
+	t.Parallel()
+
+	wait, err := ParseResponseWait(" /api/product/*  200 ")
+	if err != nil || wait != (ResponseWait{URL: "/api/product/*", Status: 200}) {
+		t.Fatalf("unexpected response wait: %+v, %v", wait, err)
+	}
+	for _, value := range []string{"", "/api 200 extra", "/api ok", "/api 42"} {
+		if _, err := ParseResponseWait(value); !errors.Is(err, ErrInvalidResponseWait) {
+			t.Fatalf("%q: expected ErrInvalidResponseWait, got %v", value, err)
+		}
+	}
+	if !errors.Is(ErrResponseTimeout, ErrWaitTimeout) {
+		t.Fatal("expected ErrResponseTimeout to wrap ErrWaitTimeout")
+	}
+}
+
+func TestResponseWaiter(t *testing.T) {
+	t.Parallel()
+
+	response := func(id, url string, status int64) *network.EventResponseReceived {
+		return &network.EventResponseReceived{RequestID: network.RequestID(id), Response: &network.Response{URL: url, Status: status}}
+	}
+	finished := func(id string) *network.EventLoadingFinished {
+		return &network.EventLoadingFinished{RequestID: network.RequestID(id)}
+	}
+
+	waiter := newResponseWaiter(ResponseWait{URL: "/api/product/*", Status: 200})
+	waiter.record(response("1", "https://example.com/api/product/1", 500))
+	waiter.record(finished("1"))
+	waiter.record(response("2", "https://example.com/api/cart", 200))
+	waiter.record(finished("2"))
+	waiter.record(response("3", "https://example.com/api/product/123?lang=de", 200))
+	if timedOut, err := waiter.waitFor(context.Background(), 10*time.Millisecond); err != nil || !timedOut {
+		t.Fatalf("expected timeout before the response finished, got %v, %v", timedOut, err)
+	}
+	waiter.record(finished("3"))
+	if timedOut, err := waiter.waitFor(context.Background(), 10*time.Millisecond); err != nil || timedOut {
+		t.Fatalf("expected matching response, got %v, %v", timedOut, err)
+	}
+
+	// full URL patterns match the whole URL
+	waiter = newResponseWaiter(ResponseWait{URL: "https://api.example.com/*"})
+	waiter.record(response("1", "https://cdn.example.com/app.js", 200))
+	waiter.record(finished("1"))
+	waiter.record(response("2", "https://api.example.com/graphql", 404))
+	waiter.record(finished("2"))
+	if timedOut, err := waiter.waitFor(context.Background(), 0); err != nil || timedOut {
+		t.Fatalf("expected matching response, got %v, %v", timedOut, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	waiter = newResponseWaiter(ResponseWait{URL: "*"})
+	if _, err := waiter.waitFor(ctx, time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Package prerendertest provides a fake prerender.Renderer for tests that run
+// without a browser.
+package prerendertest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/IncorrectM/precrawl/internal/prerender"
+)
+
+// Name is the renderer name set on results of Renderer.
+const Name = "fake"
+
+var ErrNoPage = errors.New("no canned page for target url")
+
+// Page is the canned outcome of rendering one target URL.
+type Page struct {
+	HTML string
+	// NoScriptHTML, if set, is returned instead of HTML when the render has
+	// JavaScript disabled.
+	NoScriptHTML string
+	// Data, if set, is returned instead of HTML as with binary formats.
+	Data        []byte
+	ContentType string
+	// HAR is returned when the render records one.
+	HAR *prerender.HAR
+	// Status of 400 or above is reported as a failed document request in
+	// the result's diagnostics, as the browser would report it.
+	Status int
+	// Delay is waited out before the result is returned, unless the render
+	// context is done first.
+	Delay time.Duration
+	// Err is returned alongside the result; use prerender.ErrWaitTimeout to
+	// simulate a selector timeout.
+	Err error
+}
+
+// Renderer returns canned pages keyed by target URL or by its path and query.
+// It is safe for concurrent use.
+type Renderer struct {
+	mu       sync.Mutex
+	pages    map[string]Page
+	requests []prerender.Request
+}
+
+// New returns a Renderer serving pages. Targets without a page fail with
+// ErrNoPage.
+func New(pages map[string]Page) *Renderer {
+	r := &Renderer{pages: make(map[string]Page, len(pages))}
+	for key, page := range pages {
+		r.pages[key] = page
+	}
+	return r
+}
+
+// Set adds or replaces the page for key.
+func (r *Renderer) Set(key string, page Page) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pages[key] = page
+}
+
+// Requests returns the requests rendered so far, in order.
+func (r *Renderer) Requests() []prerender.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]prerender.Request(nil), r.requests...)
+}
+
+func (r *Renderer) Render(ctx context.Context, req prerender.Request) (prerender.Result, error) {
+	// the fake stands in for the browser, so it applies the browser defaults
+	req.Options = req.WithBrowserDefaults()
+	page, ok := r.lookup(req)
+	if !ok {
+		return prerender.Result{Renderer: Name}, ErrNoPage
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if page.Delay > 0 {
+		timer := time.NewTimer(page.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return prerender.Result{Renderer: Name}, ctx.Err()
+		}
+	}
+
+	if req.DisableJavaScript && page.NoScriptHTML != "" {
+		page.HTML = page.NoScriptHTML
+	}
+	result := prerender.Result{
+		HTML:        page.HTML,
+		Data:        page.Data,
+		ContentType: page.ContentType,
+		Renderer:    Name,
+		Timings:     prerender.Timings{Navigation: page.Delay},
+	}
+	if req.RecordHAR {
+		result.HAR = page.HAR
+	}
+	if result.ContentType == "" {
+		result.ContentType = req.Format.ContentType()
+	}
+	if page.Status >= http.StatusBadRequest {
+		result.Diagnostics.FailedRequests = append(result.Diagnostics.FailedRequests, prerender.FailedRequest{
+			URL:          req.TargetURL,
+			Method:       http.MethodGet,
+			ResourceType: "Document",
+			Status:       int64(page.Status),
+		})
+	}
+	return result, page.Err
+}
+
+// lookup records req and finds its page by full URL, then by path and query.
+func (r *Renderer) lookup(req prerender.Request) (Page, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+
+	if page, ok := r.pages[req.TargetURL]; ok {
+		return page, true
+	}
+	u, err := url.Parse(req.TargetURL)
+	if err != nil {
+		return Page{}, false
+	}
+	page, ok := r.pages[u.RequestURI()]
+	return page, ok
+}
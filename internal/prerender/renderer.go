@@ -0,0 +1,260 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/IncorrectM/precrawl/internal/browser"
+)
+
+const (
+	RendererChrome = "chrome"
+	RendererHTTP   = "http"
+	// RendererAuto fetches the raw HTML first and only renders in the browser
+	// when the wait selector is missing from it.
+	RendererAuto = "auto"
+)
+
+const (
+	defaultFetchTimeout = 30 * time.Second
+	// maxFetchBytes caps the documents the HTTP renderer reads.
+	maxFetchBytes = 10 << 20
+)
+
+var (
+	ErrUnknownRenderer  = errors.New("unknown renderer")
+	ErrBrowserRequired  = errors.New("render options require a browser")
+	ErrNotHTML          = errors.New("response is not an html document")
+	ErrResponseTooLarge = errors.New("response exceeds size limit")
+)
+
+// Renderer produces the output for a render request.
+type Renderer interface {
+	Render(ctx context.Context, req Request) (Result, error)
+}
+
+// ParseRenderer normalizes a renderer name; empty selects RendererChrome.
+func ParseRenderer(name string) (string, error) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "":
+		return RendererChrome, nil
+	case RendererChrome, RendererHTTP, RendererAuto:
+		return name, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownRenderer, name)
+}
+
+// NewRenderers returns the built-in renderers keyed by name. A nil client
+// uses a client with a 30s timeout.
+func NewRenderers(pool *browser.Pool, client *http.Client) map[string]Renderer {
+	chrome := &ChromeRenderer{Pool: pool}
+	fetch := &HTTPRenderer{Client: client}
+	return map[string]Renderer{
+		RendererChrome: chrome,
+		RendererHTTP:   fetch,
+		RendererAuto:   &AutoRenderer{HTTP: fetch, Browser: chrome},
+	}
+}
+
+// ChromeRenderer renders pages in a browser from Pool.
+type ChromeRenderer struct {
+	Pool *browser.Pool
+}
+
+func (r *ChromeRenderer) Render(ctx context.Context, req Request) (Result, error) {
+	req.Options = req.WithBrowserDefaults()
+	result, err := Do(ctx, r.Pool, req)
+	result.Renderer = RendererChrome
+	return result, err
+}
+
+// HTTPRenderer fetches the document with a plain GET request and returns it
+// as served, without running scripts. Only HTML output is supported, and
+// options that need a page such as actions, scripts or device emulation
+// fail with ErrBrowserRequired. Missing the wait selector is reported as
+// ErrWaitTimeout alongside the document, as the browser renderer does.
+type HTTPRenderer struct {
+	// Client sends the request; nil uses a client with a 30s timeout.
+	Client *http.Client
+}
+
+var defaultFetchClient = &http.Client{Timeout: defaultFetchTimeout}
+
+func (r *HTTPRenderer) Render(ctx context.Context, req Request) (Result, error) {
+	found, result, err := r.fetch(ctx, req)
+	if err == nil && !found {
+		err = ErrWaitTimeout
+	}
+	return result, err
+}
+
+// fetch returns the raw document and whether the wait selector is in it.
+func (r *HTTPRenderer) fetch(ctx context.Context, req Request) (found bool, result Result, err error) {
+	if err := req.normalize(); err != nil {
+		return false, Result{}, err
+	}
+	if req.needsBrowser() {
+		return false, Result{}, ErrBrowserRequired
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var timings Timings
+	defer func() {
+		result.Renderer = RendererHTTP
+		result.Timings = timings
+	}()
+
+	var body []byte
+	err = measure(&timings.Navigation, func() error {
+		var fetchErr error
+		body, fetchErr = r.get(ctx, req, &result.Diagnostics)
+		return fetchErr
+	})
+	if err != nil {
+		return false, result, err
+	}
+
+	captureStart := time.Now()
+	defer func() {
+		timings.Capture = time.Since(captureStart)
+	}()
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return false, result, err
+	}
+	if req.QuerySelector != "" {
+		// selectors this matcher cannot evaluate count as missing
+		found, _ = matchSelector(doc, req.QuerySelector)
+	} else {
+		found = true
+	}
+	result.HTML = string(body)
+	result.ContentType = req.Format.ContentType()
+	return found, result, nil
+}
+
+func (r *HTTPRenderer) get(ctx context.Context, req Request, diagnostics *Diagnostics) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.TargetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "text/html,application/xhtml+xml")
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	if language := req.AcceptLanguage; language != "" {
+		httpReq.Header.Set("Accept-Language", language)
+	} else if req.Locale != "" {
+		httpReq.Header.Set("Accept-Language", req.Locale)
+	}
+	for _, c := range req.Cookies {
+		httpReq.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+
+	client := r.Client
+	if client == nil {
+		client = defaultFetchClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		diagnostics.FailedRequests = append(diagnostics.FailedRequests, FailedRequest{URL: req.TargetURL, Method: http.MethodGet, ResourceType: "Document", Error: err.Error()})
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		diagnostics.FailedRequests = append(diagnostics.FailedRequests, FailedRequest{URL: req.TargetURL, Method: http.MethodGet, ResourceType: "Document", Status: int64(resp.StatusCode)})
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("%w: %q", ErrNotHTML, resp.Header.Get("Content-Type"))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxFetchBytes {
+		return nil, ErrResponseTooLarge
+	}
+	return body, nil
+}
+
+// AutoRenderer serves the raw document from HTTP when it already contains
+// the wait selector and was fetched without an error status, and renders it
+// with Browser otherwise.
+type AutoRenderer struct {
+	HTTP    *HTTPRenderer
+	Browser Renderer
+}
+
+func (r *AutoRenderer) Render(ctx context.Context, req Request) (Result, error) {
+	if !req.needsBrowser() {
+		found, result, err := r.HTTP.fetch(ctx, req)
+		if err == nil && found && len(result.Diagnostics.FailedRequests) == 0 {
+			return result, nil
+		}
+		if ctx != nil && ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+	}
+	return r.Browser.Render(ctx, req)
+}
+
+// BrowserDefaults are server-wide settings that only a browser can honor,
+// such as a default device or resource limits. Keeping them apart from the
+// request's own options lets the http renderer, and auto before it falls
+// back to the browser, fetch the document without them. A default should
+// only be set when the request does not choose the option itself.
+type BrowserDefaults struct {
+	Device       *Device
+	Timezone     string
+	Geolocation  *Geolocation
+	ShadowDOM    bool
+	InlineFrames *FrameInlining
+	RecordHAR    bool
+	Limits       ResourceLimits
+}
+
+// WithBrowserDefaults returns o with o.Defaults applied to the options it
+// leaves unset, for renders that run in a browser.
+func (o Options) WithBrowserDefaults() Options {
+	d := o.Defaults
+	o.Defaults = BrowserDefaults{}
+	if o.Device == nil {
+		o.Device = d.Device
+	}
+	if o.Timezone == "" {
+		o.Timezone = d.Timezone
+	}
+	if o.Geolocation == nil {
+		o.Geolocation = d.Geolocation
+	}
+	o.ShadowDOM = o.ShadowDOM || d.ShadowDOM
+	if o.InlineFrames == nil {
+		o.InlineFrames = d.InlineFrames
+	}
+	o.RecordHAR = o.RecordHAR || d.RecordHAR
+	if !o.Limits.enabled() {
+		o.Limits = d.Limits
+	}
+	return o
+}
+
+// needsBrowser reports whether the options can only be honored by rendering
+// the page in a browser. Defaults are not considered.
+func (o Options) needsBrowser() bool {
+	return o.Format.Binary() || len(o.Scripts) > 0 || len(o.Actions) > 0 || o.AutoScroll != nil ||
+		o.ShadowDOM || o.InlineFrames != nil || o.WaitResponse != nil ||
+		o.Timezone != "" || o.Geolocation != nil || o.RecordHAR || o.Limits.enabled() ||
+		o.Device != nil
+}
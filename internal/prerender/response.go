@@ -0,0 +1,149 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+var (
+	ErrInvalidResponseWait = errors.New("invalid response wait")
+	// ErrResponseTimeout wraps ErrWaitTimeout, so a render whose response wait
+	// timed out still returns its output.
+	ErrResponseTimeout = fmt.Errorf("response %w", ErrWaitTimeout)
+)
+
+// ResponseWait delays capture until a network response matching URL and
+// Status has finished loading.
+type ResponseWait struct {
+	// URL is matched against the full response URL, or against its path and
+	// query when it starts with "/". "*" matches any run of characters.
+	URL string
+	// Status is the required HTTP status; zero accepts any.
+	Status int
+	// Timeout bounds the wait; zero uses the request's wait timeout.
+	Timeout time.Duration
+}
+
+// ParseResponseWait parses "pattern [status]", e.g. "/api/product/* 200".
+func ParseResponseWait(value string) (ResponseWait, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return ResponseWait{}, fmt.Errorf("%w: %q", ErrInvalidResponseWait, value)
+	}
+	w := ResponseWait{URL: fields[0]}
+	if len(fields) == 2 {
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return ResponseWait{}, fmt.Errorf("%w: status %q", ErrInvalidResponseWait, fields[1])
+		}
+		w.Status = status
+	}
+	return w, w.Validate()
+}
+
+// Validate reports whether the pattern, status and timeout are usable.
+func (w ResponseWait) Validate() error {
+	if strings.TrimSpace(w.URL) == "" {
+		return fmt.Errorf("%w: url pattern is empty", ErrInvalidResponseWait)
+	}
+	if w.Status != 0 && (w.Status < 100 || w.Status > 599) {
+		return fmt.Errorf("%w: status %d", ErrInvalidResponseWait, w.Status)
+	}
+	if w.Timeout < 0 {
+		return fmt.Errorf("%w: timeout must be non-negative", ErrInvalidResponseWait)
+	}
+	return nil
+}
+
+// pattern compiles URL into an anchored regular expression.
+func (w ResponseWait) pattern() *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(w.URL), `\*`, ".*") + "$")
+}
+
+// matches reports whether a response for rawURL with status satisfies w;
+// pattern is w.pattern().
+func (w ResponseWait) matches(pattern *regexp.Regexp, rawURL string, status int) bool {
+	subject := rawURL
+	if strings.HasPrefix(w.URL, "/") {
+		if u, err := url.Parse(rawURL); err == nil {
+			subject = u.RequestURI()
+		}
+	}
+	return pattern.MatchString(subject) && (w.Status == 0 || w.Status == status)
+}
+
+// responseWaiter is a chromedp target listener that latches once a matching
+// response has finished loading.
+type responseWaiter struct {
+	wait    ResponseWait
+	pattern *regexp.Regexp
+
+	mu      sync.Mutex
+	pending map[network.RequestID]bool
+	done    chan struct{}
+	closed  bool
+}
+
+func newResponseWaiter(w ResponseWait) *responseWaiter {
+	return &responseWaiter{
+		wait:    w,
+		pattern: w.pattern(),
+		pending: make(map[network.RequestID]bool),
+		done:    make(chan struct{}),
+	}
+}
+
+// record must not block.
+func (r *responseWaiter) record(ev any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	switch ev := ev.(type) {
+	case *network.EventResponseReceived:
+		if ev.Response == nil {
+			return
+		}
+		if r.wait.matches(r.pattern, ev.Response.URL, int(ev.Response.Status)) {
+			r.pending[ev.RequestID] = true
+		}
+	case *network.EventLoadingFinished:
+		if r.pending[ev.RequestID] {
+			r.closed = true
+			close(r.done)
+		}
+	case *network.EventLoadingFailed:
+		delete(r.pending, ev.RequestID)
+	}
+}
+
+// waitFor blocks until a matching response finished loading. It reports a
+// timeout instead of an error when timeout elapses first; a zero timeout
+// waits until ctx is done.
+func (r *responseWaiter) waitFor(ctx context.Context, timeout time.Duration) (timedOut bool, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrResponseTimeout)
+		defer cancel()
+	}
+	select {
+	case <-r.done:
+		return false, nil
+	case <-ctx.Done():
+		if errors.Is(context.Cause(ctx), ErrResponseTimeout) {
+			return true, nil
+		}
+		return false, ctx.Err()
+	}
+}
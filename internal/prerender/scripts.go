@@ -0,0 +1,34 @@
+package prerender
+
+import (
+	"context"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// injectScripts registers scripts to run in every new document before the
+// page's own scripts. It returns their identifiers for removeScripts.
+func injectScripts(ctx context.Context, scripts []string) ([]page.ScriptIdentifier, error) {
+	ids := make([]page.ScriptIdentifier, 0, len(scripts))
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		for _, script := range scripts {
+			id, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	}))
+	return ids, err
+}
+
+// removeScripts unregisters scripts added by injectScripts.
+func removeScripts(ctx context.Context, ids []page.ScriptIdentifier) error {
+	actions := make([]chromedp.Action, 0, len(ids))
+	for _, id := range ids {
+		actions = append(actions, page.RemoveScriptToEvaluateOnNewDocument(id))
+	}
+	return runEach(ctx, actions...)
+}
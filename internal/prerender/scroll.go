@@ -0,0 +1,85 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	defaultScrollDelay       = 250 * time.Millisecond
+	defaultScrollMaxSteps    = 50
+	defaultScrollMaxDuration = 10 * time.Second
+)
+
+var ErrInvalidAutoScroll = errors.New("invalid auto-scroll options")
+
+// AutoScroll scrolls the page down in steps so lazy content loads. Scrolling
+// stops once the bottom is reached and the height stops growing, or when
+// MaxSteps or MaxDuration is reached. Zero values use the defaults.
+type AutoScroll struct {
+	// StepPx is the distance of one step. Defaults to the viewport height.
+	StepPx int
+	// Delay is the pause after each step for content to load. Defaults to 250ms.
+	Delay time.Duration
+	// MaxSteps defaults to 50.
+	MaxSteps int
+	// MaxDuration defaults to 10s.
+	MaxDuration time.Duration
+}
+
+func (a AutoScroll) validate() error {
+	if a.StepPx < 0 || a.Delay < 0 || a.MaxSteps < 0 || a.MaxDuration < 0 {
+		return fmt.Errorf("%w: values must not be negative", ErrInvalidAutoScroll)
+	}
+	return nil
+}
+
+type scrollPosition struct {
+	Bottom float64 `json:"bottom"`
+	Height float64 `json:"height"`
+}
+
+// autoScroll scrolls to the end of the page within the budget and back to
+// the top. Running out of budget is not an error.
+func autoScroll(ctx context.Context, a AutoScroll) error {
+	delay, maxSteps, maxDuration := a.Delay, a.MaxSteps, a.MaxDuration
+	if delay == 0 {
+		delay = defaultScrollDelay
+	}
+	if maxSteps == 0 {
+		maxSteps = defaultScrollMaxSteps
+	}
+	if maxDuration == 0 {
+		maxDuration = defaultScrollMaxDuration
+	}
+	script := fmt.Sprintf(`(() => {
+		window.scrollBy(0, %d || window.innerHeight);
+		const el = document.scrollingElement || document.documentElement;
+		return {bottom: window.scrollY + window.innerHeight, height: el.scrollHeight};
+	})()`, a.StepPx)
+
+	scrollCtx, cancel := context.WithTimeout(ctx, maxDuration)
+	defer cancel()
+
+	lastHeight := -1.0
+	for range maxSteps {
+		var pos scrollPosition
+		err := chromedp.Run(scrollCtx, chromedp.Evaluate(script, &pos), chromedp.Sleep(delay))
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return err
+		}
+		if pos.Bottom >= pos.Height && pos.Height == lastHeight {
+			break
+		}
+		lastHeight = pos.Height
+	}
+
+	return chromedp.Run(ctx, chromedp.Evaluate(`window.scrollTo(0, 0)`, nil))
+}
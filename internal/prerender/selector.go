@@ -0,0 +1,189 @@
+package prerender
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var ErrUnsupportedSelector = errors.New("unsupported selector")
+
+// compound is a simple CSS compound selector such as div#main.content[data-ready].
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	key   string
+	value string
+	exact bool
+}
+
+// matchSelector reports whether an element in doc matches selector. Only type,
+// id, class and attribute selectors, the descendant combinator and selector
+// lists are supported; anything else returns ErrUnsupportedSelector.
+func matchSelector(doc *html.Node, selector string) (bool, error) {
+	for _, group := range strings.Split(selector, ",") {
+		chain, err := parseSelectorChain(group)
+		if err != nil {
+			return false, err
+		}
+		if findMatch(doc, chain) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parseSelectorChain(group string) ([]compound, error) {
+	fields := strings.Fields(group)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: empty selector", ErrUnsupportedSelector)
+	}
+	chain := make([]compound, 0, len(fields))
+	for _, field := range fields {
+		c, err := parseCompound(field)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, c)
+	}
+	return chain, nil
+}
+
+func parseCompound(s string) (compound, error) {
+	var c compound
+	i := 0
+	ident := func() string {
+		start := i
+		for i < len(s) && (isIdentByte(s[i])) {
+			i++
+		}
+		return s[start:i]
+	}
+
+	if i < len(s) && s[i] == '*' {
+		i++
+	} else {
+		c.tag = strings.ToLower(ident())
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '#':
+			i++
+			if c.id = ident(); c.id == "" {
+				return c, fmt.Errorf("%w: %q", ErrUnsupportedSelector, s)
+			}
+		case '.':
+			i++
+			class := ident()
+			if class == "" {
+				return c, fmt.Errorf("%w: %q", ErrUnsupportedSelector, s)
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return c, fmt.Errorf("%w: %q", ErrUnsupportedSelector, s)
+			}
+			attr, err := parseAttrSelector(s[i+1 : i+end])
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attr)
+			i += end + 1
+		default:
+			return c, fmt.Errorf("%w: %q", ErrUnsupportedSelector, s)
+		}
+	}
+	return c, nil
+}
+
+func parseAttrSelector(s string) (attrSelector, error) {
+	key, value, exact := strings.Cut(s, "=")
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "" || strings.ContainsAny(key, "~|^$*") {
+		return attrSelector{}, fmt.Errorf("%w: [%s]", ErrUnsupportedSelector, s)
+	}
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	return attrSelector{key: key, value: value, exact: exact}, nil
+}
+
+func isIdentByte(b byte) bool {
+	return b == '-' || b == '_' || b >= 0x80 ||
+		(b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+func (c compound) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (c.tag != "" && n.Data != c.tag) {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, class := range c.classes {
+		found := false
+		for _, have := range classes {
+			if have == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := lookupAttr(n, a.key)
+		if !ok || (a.exact && value != a.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// findMatch reports whether an element matches the last compound of chain
+// with ancestors matching the preceding compounds in order.
+func findMatch(n *html.Node, chain []compound) bool {
+	if chain[len(chain)-1].matches(n) && ancestorsMatch(n.Parent, chain[:len(chain)-1]) {
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if findMatch(c, chain) {
+			return true
+		}
+	}
+	return false
+}
+
+func ancestorsMatch(n *html.Node, chain []compound) bool {
+	if len(chain) == 0 {
+		return true
+	}
+	for ; n != nil; n = n.Parent {
+		if chain[len(chain)-1].matches(n) && ancestorsMatch(n.Parent, chain[:len(chain)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	value, _ := lookupAttr(n, key)
+	return value
+}
+
+func lookupAttr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,70 @@
+package prerender
+
+import (
+	"context"
+
+	"github.com/chromedp/chromedp"
+)
+
+// shadowHTMLScript serializes the document with open shadow roots as
+// declarative shadow DOM (<template shadowrootmode="open">). It uses
+// Element.getHTML where available and walks the DOM otherwise.
+const shadowHTMLScript = `(() => {
+	const root = document.documentElement;
+	const shell = root.cloneNode(false).outerHTML;
+	const close = '</' + root.localName + '>';
+	const wrap = inner => shell.slice(0, shell.length - close.length) + inner + close;
+
+	if (typeof root.getHTML === 'function') {
+		const shadowRoots = [];
+		const collect = node => {
+			for (const el of node.querySelectorAll('*')) {
+				if (el.shadowRoot && el.shadowRoot.mode === 'open') {
+					shadowRoots.push(el.shadowRoot);
+					collect(el.shadowRoot);
+				}
+			}
+		};
+		collect(document);
+		return wrap(root.getHTML({serializableShadowRoots: true, shadowRoots}));
+	}
+
+	const voids = new Set(['area', 'base', 'br', 'col', 'embed', 'hr', 'img', 'input', 'link', 'meta', 'source', 'track', 'wbr']);
+	const raw = new Set(['script', 'style', 'xmp', 'iframe', 'noembed', 'noframes', 'plaintext', 'noscript']);
+	const escapeText = s => s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;').replace(/\u00a0/g, '&nbsp;');
+	const escapeAttr = s => s.replace(/&/g, '&amp;').replace(/"/g, '&quot;').replace(/\u00a0/g, '&nbsp;');
+	const children = (node, parentName) => {
+		let out = '';
+		for (const child of node.childNodes) out += serialize(child, parentName);
+		return out;
+	};
+	const serialize = (node, parentName) => {
+		switch (node.nodeType) {
+		case Node.TEXT_NODE:
+			return raw.has(parentName) ? node.data : escapeText(node.data);
+		case Node.COMMENT_NODE:
+			return '<!--' + node.data + '-->';
+		case Node.ELEMENT_NODE: {
+			const name = node.localName;
+			let out = '<' + name;
+			for (const attr of node.attributes) out += ' ' + attr.name + '="' + escapeAttr(attr.value) + '"';
+			out += '>';
+			if (voids.has(name)) return out;
+			if (node.shadowRoot && node.shadowRoot.mode === 'open') {
+				out += '<template shadowrootmode="open">' + children(node.shadowRoot, '') + '</template>';
+			}
+			out += children(name === 'template' ? node.content : node, name);
+			return out + '</' + name + '>';
+		}
+		}
+		return '';
+	};
+	return wrap(children(root, root.localName));
+})()`
+
+// shadowHTML returns the document HTML including open shadow roots.
+func shadowHTML(ctx context.Context) (string, error) {
+	var html string
+	err := chromedp.Run(ctx, chromedp.Evaluate(shadowHTMLScript, &html))
+	return html, err
+}
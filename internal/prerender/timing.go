@@ -0,0 +1,85 @@
+package prerender
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// vitalsTimeout bounds the wait for buffered largest-contentful-paint entries.
+const vitalsTimeout = 200 * time.Millisecond
+
+// Timings breaks a render down into its phases. Phases that did not run are
+// zero. DOMContentLoaded, FCP and LCP are measured by the page relative to
+// navigation start.
+type Timings struct {
+	Acquire      time.Duration
+	Navigation   time.Duration
+	ResponseWait time.Duration
+	SelectorWait time.Duration
+	Sleep        time.Duration
+	AutoScroll   time.Duration
+	Actions      time.Duration
+	Capture      time.Duration
+
+	DOMContentLoaded time.Duration
+	FCP              time.Duration
+	LCP              time.Duration
+}
+
+func (t Timings) String() string {
+	return fmt.Sprintf("acquire=%s navigation=%s responseWait=%s selectorWait=%s sleep=%s autoScroll=%s actions=%s capture=%s domContentLoaded=%s fcp=%s lcp=%s",
+		t.Acquire.Round(time.Millisecond), t.Navigation.Round(time.Millisecond), t.ResponseWait.Round(time.Millisecond), t.SelectorWait.Round(time.Millisecond),
+		t.Sleep.Round(time.Millisecond), t.AutoScroll.Round(time.Millisecond), t.Actions.Round(time.Millisecond),
+		t.Capture.Round(time.Millisecond), t.DOMContentLoaded.Round(time.Millisecond), t.FCP.Round(time.Millisecond),
+		t.LCP.Round(time.Millisecond))
+}
+
+// measure runs fn and stores its duration in *d.
+func measure(d *time.Duration, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	*d = time.Since(start)
+	return err
+}
+
+const vitalsScript = `new Promise(resolve => {
+	const nav = performance.getEntriesByType('navigation')[0];
+	const fcp = performance.getEntriesByName('first-contentful-paint')[0];
+	const result = {dcl: nav ? nav.domContentLoadedEventEnd : 0, fcp: fcp ? fcp.startTime : 0, lcp: 0};
+	try {
+		new PerformanceObserver(list => {
+			const entries = list.getEntries();
+			result.lcp = entries[entries.length - 1].startTime;
+		}).observe({type: 'largest-contentful-paint', buffered: true});
+	} catch (e) {}
+	setTimeout(() => resolve(result), %d);
+})`
+
+type pageVitals struct {
+	DCL float64 `json:"dcl"`
+	FCP float64 `json:"fcp"`
+	LCP float64 `json:"lcp"`
+}
+
+// collectVitals reads DOMContentLoaded, FCP and LCP from the Performance API.
+func collectVitals(ctx context.Context, t *Timings) error {
+	var vitals pageVitals
+	script := fmt.Sprintf(vitalsScript, vitalsTimeout.Milliseconds())
+	if err := chromedp.Run(ctx, chromedp.Evaluate(script, &vitals, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return p.WithAwaitPromise(true)
+	})); err != nil {
+		return err
+	}
+	t.DOMContentLoaded = fromMillis(vitals.DCL)
+	t.FCP = fromMillis(vitals.FCP)
+	t.LCP = fromMillis(vitals.LCP)
+	return nil
+}
+
+func fromMillis(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/IncorrectM/precrawl/internal/prerender"
+	"github.com/IncorrectM/precrawl/internal/task"
+)
+
+// maxDiffLines caps the text lines and links listed in a diff report.
+const maxDiffLines = 200
+
+// diffReport compares a page rendered with JavaScript to the same page
+// rendered without it.
+type diffReport struct {
+	TargetURL string      `json:"target_url"`
+	Rendered  pageSummary `json:"rendered"`
+	NoJS      pageSummary `json:"no_js"`
+	// TextCoverage is the share of the rendered page's words that are also
+	// present without JavaScript.
+	TextCoverage float64 `json:"text_coverage"`
+	// OnlyRendered and OnlyNoJS list the text lines found on one side only.
+	OnlyRendered []string `json:"only_rendered"`
+	OnlyNoJS     []string `json:"only_no_js"`
+	// MissingLinks are link targets that only appear with JavaScript.
+	MissingLinks []string `json:"missing_links"`
+	Truncated    bool     `json:"truncated,omitempty"`
+}
+
+// pageSummary holds what a crawler would extract from one side of the diff.
+type pageSummary struct {
+	Error       string   `json:"error,omitempty"`
+	Bytes       int      `json:"bytes"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Canonical   string   `json:"canonical,omitempty"`
+	Headings    []string `json:"headings"`
+	Links       int      `json:"links"`
+	Words       int      `json:"words"`
+
+	lines []string
+	words []string
+	hrefs []string
+}
+
+// handleDiff renders taskItem with and without JavaScript through the queue
+// and writes a diffReport. Both renders use the chrome renderer if available.
+func handleDiff(w http.ResponseWriter, r *http.Request, cfg Config, taskItem task.Task, start time.Time) {
+	if taskItem.Options.Format.Binary() {
+		http.Error(w, "diff requires html output", http.StatusBadRequest)
+		return
+	}
+	if _, ok := cfg.Renderers[prerender.RendererChrome]; ok {
+		taskItem.Renderer = prerender.RendererChrome
+	}
+	taskItem.Options.DisableJavaScript = false
+	noJSOptions := *taskItem.Options
+	noJSOptions.DisableJavaScript = true
+	noJSItem := taskItem
+	noJSItem.Options = &noJSOptions
+
+	renderedCh, noJSCh := make(chan task.Result, 1), make(chan task.Result, 1)
+	taskItem.ResultCh, noJSItem.ResultCh = renderedCh, noJSCh
+	for _, item := range []task.Task{taskItem, noJSItem} {
+		if err := cfg.Queue.Enqueue(item); err != nil {
+			log.Printf("enqueue failed target=%s err=%v", item.TargetURL, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var rendered, noJS task.Result
+	for range 2 {
+		select {
+		case rendered = <-renderedCh:
+			renderedCh = nil
+		case noJS = <-noJSCh:
+			noJSCh = nil
+		case <-r.Context().Done():
+			log.Printf("request canceled target=%s err=%v duration=%s", taskItem.TargetURL, r.Context().Err(), time.Since(start))
+			http.Error(w, "request canceled", http.StatusRequestTimeout)
+			return
+		}
+	}
+
+	report := diffRenders(taskItem.TargetURL, rendered, noJS)
+	status := http.StatusOK
+	if rendered.Err != nil || noJS.Err != nil {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("diff encode failed target=%s err=%v", taskItem.TargetURL, err)
+	}
+	log.Printf("render diff target=%s textCoverage=%.2f onlyRendered=%d missingLinks=%d duration=%s", taskItem.TargetURL, report.TextCoverage, len(report.OnlyRendered), len(report.MissingLinks), time.Since(start))
+}
+
+// diffRenders builds the report for the renders with and without JavaScript.
+func diffRenders(targetURL string, rendered, noJS task.Result) diffReport {
+	report := diffReport{
+		TargetURL: targetURL,
+		Rendered:  summarizePage(rendered, true),
+		NoJS:      summarizePage(noJS, false),
+	}
+
+	var linesCut, noJSCut, linksCut bool
+	report.OnlyRendered, linesCut = subtract(report.Rendered.lines, report.NoJS.lines)
+	report.OnlyNoJS, noJSCut = subtract(report.NoJS.lines, report.Rendered.lines)
+	report.MissingLinks, linksCut = subtract(report.Rendered.hrefs, report.NoJS.hrefs)
+	report.Truncated = linesCut || noJSCut || linksCut
+
+	report.TextCoverage = 1
+	if total := len(report.Rendered.words); total > 0 {
+		missing := len(subtractAll(report.Rendered.words, report.NoJS.words))
+		report.TextCoverage = float64(total-missing) / float64(total)
+	}
+	return report
+}
+
+// subtract returns the entries of a that are not in b, counting duplicates,
+// in order and capped at maxDiffLines.
+func subtract(a, b []string) ([]string, bool) {
+	only := subtractAll(a, b)
+	if len(only) > maxDiffLines {
+		return only[:maxDiffLines], true
+	}
+	return only, false
+}
+
+func subtractAll(a, b []string) []string {
+	remaining := make(map[string]int, len(b))
+	for _, s := range b {
+		remaining[s]++
+	}
+	only := []string{}
+	for _, s := range a {
+		if remaining[s] > 0 {
+			remaining[s]--
+			continue
+		}
+		only = append(only, s)
+	}
+	return only
+}
+
+// textSkipped are elements whose content is not visible text.
+var textSkipped = []string{"script", "style", "template", "svg"}
+
+// blockElements end the current text line.
+var blockElements = []string{
+	"address", "article", "aside", "blockquote", "br", "dd", "div", "dl", "dt", "figcaption", "figure",
+	"footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr", "li", "main", "nav", "ol", "p",
+	"pre", "section", "table", "td", "th", "tr", "ul",
+}
+
+// summarizePage extracts the title, meta tags, headings, links and text lines
+// of a rendered document. With scripting, noscript content is ignored as a
+// browser would; without it, noscript content is visible.
+func summarizePage(result task.Result, scripting bool) pageSummary {
+	summary := pageSummary{Bytes: len(result.HTML), Headings: []string{}}
+	if result.Err != nil {
+		summary.Error = result.Err.Error()
+	}
+	doc, err := html.ParseWithOptions(strings.NewReader(result.HTML), html.ParseOptionEnableScripting(scripting))
+	if err != nil {
+		if summary.Error == "" {
+			summary.Error = fmt.Sprintf("parse rendered html: %v", err)
+		}
+		return summary
+	}
+
+	var line strings.Builder
+	flush := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			summary.lines = append(summary.lines, text)
+			summary.words = append(summary.words, strings.Fields(text)...)
+		}
+		line.Reset()
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			line.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "title":
+				if summary.Title == "" {
+					summary.Title = nodeText(n)
+				}
+				return
+			case "meta":
+				if strings.EqualFold(attr(n, "name"), "description") {
+					summary.Description = attr(n, "content")
+				}
+			case "link":
+				if strings.EqualFold(attr(n, "rel"), "canonical") {
+					summary.Canonical = attr(n, "href")
+				}
+			case "a":
+				if href := attr(n, "href"); href != "" {
+					summary.hrefs = append(summary.hrefs, href)
+				}
+			case "h1", "h2", "h3":
+				summary.Headings = append(summary.Headings, nodeText(n))
+			case "noscript":
+				if scripting {
+					return
+				}
+			}
+			if slices.Contains(textSkipped, n.Data) {
+				return
+			}
+		}
+		block := n.Type == html.ElementNode && slices.Contains(blockElements, n.Data)
+		if block {
+			flush()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			flush()
+		}
+	}
+	walk(doc)
+	flush()
+	summary.Links = len(summary.hrefs)
+	summary.Words = len(summary.words)
+	return summary
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
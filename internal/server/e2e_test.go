@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+
+	"github.com/IncorrectM/precrawl/internal/browser"
+	"github.com/IncorrectM/precrawl/internal/prerender"
+	"github.com/IncorrectM/precrawl/internal/transformer"
+)
+
+// go test ./internal/server -run TestE2E -update rewrites the golden files.
+var update = flag.Bool("update", false, "rewrite e2e golden files")
+
+const (
+	e2eDir = "testdata/e2e"
+	// fixtureHost replaces the fixture server's random address in outputs.
+	fixtureHost = "http://fixture.test"
+)
+
+// newFixtureSite serves the pages in testdata/e2e/sites:
+//
+//	/static    a page without scripts
+//	/delayed   content inserted by a script after 300ms
+//	/old       a redirect to /static
+//	/missing   a 404 with an HTML body
+//	/scroll    infinite scroll loading /api/items in three pages
+//	/errors    a console error and an uncaught exception
+//	/scripts   a report of globals set by injected scripts
+//	/endless   a list that grows by one item on every scroll event
+func newFixtureSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	page := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, err := os.ReadFile(filepath.Join(e2eDir, "sites", name))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			_, _ = w.Write(body)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/static", page("static.html", http.StatusOK))
+	mux.Handle("/delayed", page("delayed.html", http.StatusOK))
+	mux.Handle("/missing", page("notfound.html", http.StatusNotFound))
+	mux.Handle("/scroll", page("scroll.html", http.StatusOK))
+	mux.Handle("/errors", page("errors.html", http.StatusOK))
+	mux.Handle("/scripts", page("scripts.html", http.StatusOK))
+	mux.Handle("/endless", page("endless.html", http.StatusOK))
+	mux.Handle("/old", http.RedirectHandler("/static", http.StatusFound))
+	mux.HandleFunc("/api/items", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || n < 1 {
+			http.Error(w, "invalid page", http.StatusBadRequest)
+			return
+		}
+		items := make([]string, 10)
+		for i := range items {
+			items[i] = fmt.Sprintf("Item %d", (n-1)*10+i+1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(items)
+	})
+	mux.Handle("/logo.png", http.NotFoundHandler())
+
+	site := httptest.NewServer(mux)
+	t.Cleanup(site.Close)
+	return site
+}
+
+// chromePool returns a browser pool, skipping the test in short mode or when
+// Chrome is not installed.
+func chromePool(t *testing.T) *browser.Pool {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping browser e2e test in short mode")
+	}
+
+	pool, err := browser.NewPool(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := pool.AcquireBlank(ctx)
+	if err != nil {
+		t.Fatalf("AcquireBlank error: %v", err)
+	}
+	// the browser is started by the first action run on a page
+	err = chromedp.Run(page.Ctx)
+	if releaseErr := pool.Release(page); err == nil && releaseErr != nil {
+		t.Fatalf("Release error: %v", releaseErr)
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("skipping browser e2e test: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("browser start error: %v", err)
+	}
+	return pool
+}
+
+type e2eCase struct {
+	name     string
+	path     string
+	selector string
+	routes   []Route
+	scripts  []Script
+	// check inspects the debug report beyond the golden HTML.
+	check func(t *testing.T, report debugResponse)
+}
+
+// runE2E renders each case through the server, workers, renderer and default
+// transformers, and compares the output with testdata/e2e/golden/<prefix>-<name>.html.
+func runE2E(t *testing.T, prefix, renderer string, pool *browser.Pool, cases []e2eCase) {
+	site := newFixtureSite(t)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := startServer(t, Config{
+				BaseTargetURL:      site.URL,
+				DefaultWaitTimeout: 5 * time.Second,
+				Renderers:          prerender.NewRenderers(pool, site.Client()),
+				DefaultRenderer:    renderer,
+				Routes:             c.routes,
+				Scripts:            c.scripts,
+			}, transformer.DefaultTransformers()...)
+
+			resp, body := get(t, server.URL+c.path, map[string]string{selectorHeader: c.selector, debugHeader: "true"})
+			var report debugResponse
+			if err := json.Unmarshal([]byte(body), &report); err != nil {
+				t.Fatalf("Unmarshal error: %v: %s", err, body)
+			}
+			if resp.StatusCode != http.StatusOK || report.Error != "" {
+				t.Fatalf("expected render to succeed, got %d: %s", resp.StatusCode, report.Error)
+			}
+			if report.Renderer != renderer {
+				t.Fatalf("expected renderer %q, got %q", renderer, report.Renderer)
+			}
+			if c.check != nil {
+				c.check(t, report)
+			}
+			compareGolden(t, filepath.Join(e2eDir, "golden", prefix+"-"+c.name+".html"), strings.ReplaceAll(report.HTML, site.URL, fixtureHost))
+		})
+	}
+}
+
+func compareGolden(t *testing.T, file, got string) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(file, []byte(got), 0o644); err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	if got != string(want) {
+		t.Fatalf("output differs from %s\nwant: %s\ngot:  %s", file, want, got)
+	}
+}
+
+func failedStatus(status int64) func(t *testing.T, report debugResponse) {
+	return func(t *testing.T, report debugResponse) {
+		failed := report.Diagnostics.FailedRequests
+		if len(failed) == 0 || failed[0].Status != status {
+			t.Fatalf("expected a failed request with status %d, got %+v", status, failed)
+		}
+	}
+}
+
+func TestE2EHTTP(t *testing.T) {
+	t.Parallel()
+
+	runE2E(t, "http", prerender.RendererHTTP, nil, []e2eCase{
+		{name: "static", path: "/static", selector: "#content"},
+		{name: "redirect", path: "/old", selector: "#content"},
+		{name: "missing", path: "/missing", selector: "#content", check: failedStatus(http.StatusNotFound)},
+		// the script never runs, so the selector times out on the raw document
+		{name: "delayed", path: "/delayed", selector: "#content"},
+	})
+}
+
+func TestE2EChrome(t *testing.T) {
+	pool := chromePool(t)
+
+	runE2E(t, "chrome", prerender.RendererChrome, pool, []e2eCase{
+		{name: "static", path: "/static", selector: "#content"},
+		{name: "redirect", path: "/old", selector: "#content"},
+		{name: "missing", path: "/missing", selector: "#content", check: failedStatus(http.StatusNotFound)},
+		{name: "delayed", path: "/delayed", selector: "#content"},
+		{
+			name:     "scroll",
+			path:     "/scroll",
+			selector: "#items li",
+			routes: []Route{{
+				AutoScroll: &prerender.AutoScroll{Delay: 300 * time.Millisecond},
+				Actions:    []prerender.Action{{Type: prerender.ActionWaitForSelector, Selector: "#end"}},
+			}},
+		},
+		{
+			// the page reports the globals while it is parsed, so they must
+			// be set before its own scripts run
+			name:     "scripts",
+			path:     "/scripts",
+			selector: "#content",
+			scripts: []Script{
+				{Source: `Object.defineProperty(navigator, "webdriver", {get: () => false});`},
+				{Source: `window.featureFlag = "on";`, Paths: []string{"/scripts"}},
+				{Source: `window.featureFlag = "wrong";`, Paths: []string{"/other"}},
+			},
+		},
+		{
+			name:     "errors",
+			path:     "/errors",
+			selector: "#content",
+			check: func(t *testing.T, report debugResponse) {
+				if report.Diagnostics.ConsoleErrors() != 1 || len(report.Diagnostics.Exceptions) != 1 {
+					t.Fatalf("expected one console error and one exception, got %+v", report.Diagnostics)
+				}
+			},
+		},
+	})
+}
+
+// autoScrollTiming matches the auto-scroll duration in a Server-Timing header.
+var autoScrollTiming = regexp.MustCompile(`autoscroll;dur=([0-9.]+)`)
+
+func TestE2EAutoScrollStops(t *testing.T) {
+	pool := chromePool(t)
+	site := newFixtureSite(t)
+
+	cases := []struct {
+		name   string
+		path   string
+		scroll prerender.AutoScroll
+		// minItems and maxItems bound the list items in the output; scrolling
+		// back to the top adds one more item to /endless.
+		minItems, maxItems int
+		// minDuration and maxDuration bound the time spent scrolling.
+		minDuration, maxDuration time.Duration
+	}{
+		{
+			// /scroll stops loading after 30 items, so the height settles
+			// long before the step budget of 100 x 200ms is used up
+			name:        "stable height",
+			path:        "/scroll",
+			scroll:      prerender.AutoScroll{StepPx: 2000, Delay: 200 * time.Millisecond, MaxSteps: 100, MaxDuration: 30 * time.Second},
+			minItems:    30,
+			maxItems:    30,
+			maxDuration: 5 * time.Second,
+		},
+		{
+			name:        "max steps",
+			path:        "/endless",
+			scroll:      prerender.AutoScroll{Delay: 100 * time.Millisecond, MaxSteps: 3, MaxDuration: 20 * time.Second},
+			minItems:    2,
+			maxItems:    5,
+			maxDuration: 2 * time.Second,
+		},
+		{
+			name:        "timeout",
+			path:        "/endless",
+			scroll:      prerender.AutoScroll{Delay: 100 * time.Millisecond, MaxSteps: 1000, MaxDuration: time.Second},
+			minItems:    2,
+			maxItems:    13,
+			minDuration: time.Second,
+			maxDuration: 3 * time.Second,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := startServer(t, Config{
+				BaseTargetURL:      site.URL,
+				DefaultWaitTimeout: 5 * time.Second,
+				Renderers:          prerender.NewRenderers(pool, site.Client()),
+				Routes:             []Route{{AutoScroll: &c.scroll}},
+			})
+
+			resp, body := get(t, server.URL+c.path, map[string]string{selectorHeader: "#items li", debugHeader: "true"})
+			var report debugResponse
+			if err := json.Unmarshal([]byte(body), &report); err != nil {
+				t.Fatalf("Unmarshal error: %v: %s", err, body)
+			}
+			if resp.StatusCode != http.StatusOK || report.Error != "" {
+				t.Fatalf("expected render to succeed, got %d: %s", resp.StatusCode, report.Error)
+			}
+
+			if items := strings.Count(report.HTML, "<li"); items < c.minItems || items > c.maxItems {
+				t.Fatalf("expected %d to %d items, got %d", c.minItems, c.maxItems, items)
+			}
+			match := autoScrollTiming.FindStringSubmatch(resp.Header.Get("Server-Timing"))
+			if match == nil {
+				t.Fatalf("expected an autoscroll timing, got %q", resp.Header.Get("Server-Timing"))
+			}
+			ms, _ := strconv.ParseFloat(match[1], 64)
+			if d := time.Duration(ms * float64(time.Millisecond)); d < c.minDuration || d > c.maxDuration {
+				t.Fatalf("expected auto-scroll to take %s to %s, took %s", c.minDuration, c.maxDuration, d)
+			}
+		})
+	}
+}
+
+func TestE2EForwardedHeadersScope(t *testing.T) {
+	pool := chromePool(t)
+
+	// the third party is another origin that records what it receives
+	thirdPartyAuth := make(chan string, 1)
+	thirdParty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case thirdPartyAuth <- r.Header.Get("Authorization"):
+		default:
+		}
+		w.Header().Set("Content-Type", "application/javascript")
+		_, _ = w.Write([]byte(`document.getElementById('third-party').textContent = 'loaded';`))
+	}))
+	t.Cleanup(thirdParty.Close)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprintf(w, `<html><body><p id="auth">%s</p><p id="third-party"></p><script src="%s/widget.js"></script></body></html>`,
+			r.Header.Get("Authorization"), thirdParty.URL)
+	}))
+	t.Cleanup(site.Close)
+
+	server := startServer(t, Config{
+		BaseTargetURL:      site.URL,
+		DefaultWaitTimeout: 5 * time.Second,
+		Renderers:          prerender.NewRenderers(pool, site.Client()),
+		ForwardHeaders:     []string{"Authorization"},
+	})
+	resp, body := get(t, server.URL+"/", map[string]string{selectorHeader: "#third-party", "Authorization": "Bearer secret"})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `<p id="auth">Bearer secret</p>`) || !strings.Contains(body, "loaded") {
+		t.Fatalf("expected the target to receive the forwarded header, got %d: %s", resp.StatusCode, body)
+	}
+	select {
+	case auth := <-thirdPartyAuth:
+		if auth != "" {
+			t.Fatalf("expected the third party to receive no Authorization, got %q", auth)
+		}
+	default:
+		t.Fatal("expected the third-party script to be requested")
+	}
+}
@@ -1,12 +1,19 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,11 +25,34 @@ import (
 )
 
 const (
-	defaultAddr     = ":8080"
-	defaultSelector = "body"
-	selectorHeader  = "X-Render-Selector"
-	waitHeader      = "X-Render-Wait"
-	waitMsHeader    = "X-Render-Wait-Ms"
+	defaultAddr      = ":8080"
+	defaultSelector  = "body"
+	selectorHeader   = "X-Render-Selector"
+	waitHeader       = "X-Render-Wait"
+	waitMsHeader     = "X-Render-Wait-Ms"
+	formatHeader     = "X-Render-Format"
+	fullPageHeader   = "X-Render-Full-Page"
+	clipHeader       = "X-Render-Clip-Selector"
+	qualityHeader    = "X-Render-Quality"
+	pdfHeader        = "X-Render-PDF-Options"
+	deviceHeader     = "X-Render-Device"
+	localeHeader     = "X-Render-Locale"
+	languageHeader   = "X-Render-Accept-Language"
+	timezoneHeader   = "X-Render-Timezone"
+	geoHeader        = "X-Render-Geolocation"
+	extraHeaders     = "X-Render-Extra-Headers"
+	cookiesHeader    = "X-Render-Cookies"
+	actionsHeader    = "X-Render-Actions"
+	autoScrollHeader = "X-Render-Auto-Scroll"
+	debugHeader      = "X-Render-Debug"
+	shadowDOMHeader  = "X-Render-Shadow-DOM"
+	framesHeader     = "X-Render-Inline-Frames"
+	javaScriptHeader = "X-Render-JavaScript"
+	responseHeader   = "X-Render-Wait-Response"
+	formatParam      = "_render_format"
+	statsPath        = "/_precrawl/stats"
+	harPath          = "/_precrawl/har"
+	diffPath         = "/_precrawl/diff"
 )
 
 var (
@@ -38,52 +68,179 @@ type Config struct {
 	Queue              *task.TaskQueue
 	Pool               *browser.Pool
 	WorkerCount        int
+	ResourceLimits     prerender.ResourceLimits
+	// Devices are the emulation profiles selectable per request; nil uses
+	// prerender.DefaultDevices.
+	Devices map[string]prerender.Device
+	// DefaultDevice is used when a request does not select a device; empty
+	// keeps Chrome's default viewport.
+	DefaultDevice string
+	// AutoSelectDevice picks a built-in profile from the client's User-Agent
+	// when the request does not select a device.
+	AutoSelectDevice bool
+	// DefaultLocale, DefaultTimezone and DefaultGeolocation are emulated when
+	// a request does not override them; empty values keep Chrome's defaults.
+	DefaultLocale      string
+	DefaultTimezone    string
+	DefaultGeolocation *prerender.Geolocation
+	// Hosts holds the headers and cookies sent when rendering a target host,
+	// keyed by host name or host:port.
+	Hosts map[string]TargetExtras
+	// HeaderAllowlist and CookieAllowlist name the headers and cookies a
+	// request may add via X-Render-Extra-Headers and X-Render-Cookies. Empty
+	// lists reject per-request headers and cookies.
+	HeaderAllowlist []string
+	CookieAllowlist []string
+	// ForwardHeaders names the client request headers passed on to the
+	// target navigation. Cookie is forwarded as browser cookies for the target.
+	// Authorization is only forwarded when listed here. Like host headers,
+	// forwarded headers only go to the target's origin.
+	ForwardHeaders []string
+	// Scripts are injected before navigation, in order, into renders whose
+	// request path they match.
+	Scripts []Script
+	// Routes configure renders per request path; every matching route applies.
+	Routes []Route
+	// AllowRequestActions lets requests add actions via X-Render-Actions.
+	AllowRequestActions bool
+	// HARDir, if set, receives a HAR file for every render taking at least
+	// HARThreshold. Files are only readable by the server's user.
+	HARDir       string
+	HARThreshold time.Duration
+	// HAREndpoint enables /_precrawl/har/. The endpoint has no
+	// authentication, so only enable it where untrusted clients cannot reach
+	// the server.
+	HAREndpoint bool
+	// DiffEndpoint enables /_precrawl/diff/. Every request to it renders
+	// the page twice, so it is off by default like HAREndpoint.
+	DiffEndpoint bool
+	// ShadowDOM serializes open shadow roots in HTML renders unless a
+	// request turns it off.
+	ShadowDOM bool
+	// InlineFrames inlines same-origin iframes in HTML renders unless a
+	// request turns it off; nil leaves it to the request.
+	InlineFrames *prerender.FrameInlining
+	// Renderers are the renderers selectable by name; nil uses
+	// prerender.NewRenderers with Pool. Pool may be nil when Renderers is set.
+	Renderers map[string]prerender.Renderer
+	// DefaultRenderer is used for requests no route selects a renderer for;
+	// empty selects prerender.RendererChrome.
+	DefaultRenderer string
+}
+
+// renderMode selects what handleRender responds with.
+type renderMode int
+
+const (
+	// modeOutput returns the rendered document or capture.
+	modeOutput renderMode = iota
+	// modeDebug returns a JSON report with diagnostics.
+	modeDebug
+	// modeHAR returns the HAR of the render.
+	modeHAR
+	// modeDiff renders with and without JavaScript and returns a JSON
+	// report comparing the two.
+	modeDiff
+)
+
+// Route holds render settings for request paths matching Paths.
+type Route struct {
+	// Paths are path.Match patterns for the request path; empty matches all.
+	Paths []string
+	// AutoScroll scrolls matching pages to load lazy content; nil disables it.
+	AutoScroll *prerender.AutoScroll
+	// Actions run after the selector wait and before capture.
+	Actions []prerender.Action
+	// Renderer names the renderer for matching requests; empty keeps the
+	// default.
+	Renderer string
+	// WaitResponse waits for a network response before capture; nil disables it.
+	WaitResponse *prerender.ResponseWait
+}
+
+// Script is JavaScript run in every document before the page's own scripts.
+type Script struct {
+	Source string
+	// Paths are path.Match patterns for the request path; empty matches all.
+	Paths []string
+}
+
+// matchPaths reports whether requestPath matches one of patterns. An empty
+// pattern list matches every path.
+func matchPaths(patterns []string, requestPath string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, requestPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: path %q: %v", ErrInvalidConfig, pattern, err)
+		}
+	}
+	return nil
+}
+
+// unforwardableHeaders describe the connection to precrawl rather than the
+// client, or are render options, and are never forwarded.
+var unforwardableHeaders = []string{
+	"Connection", "Content-Length", "Host", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// TargetExtras are the extra headers and cookies for navigations to a host.
+type TargetExtras struct {
+	Headers map[string]string
+	Cookies []prerender.Cookie
+}
+
+// unsafeFileChars are replaced when a target URL is turned into a file name.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// varyHeaders are the request headers that change the rendered output, so
+// caches in front of the server must key on them.
+var varyHeaders = []string{
+	selectorHeader, waitHeader, waitMsHeader, formatHeader, fullPageHeader, clipHeader, qualityHeader, pdfHeader,
+	deviceHeader, localeHeader, languageHeader, timezoneHeader, geoHeader, extraHeaders, cookiesHeader,
+	actionsHeader, autoScrollHeader, debugHeader, shadowDOMHeader, framesHeader, javaScriptHeader,
+	responseHeader,
 }
 
 func Run(ctx context.Context, cfg Config, transformers []transformer.Transformer) error {
 	// initialize and validate configuration
-	if cfg.Queue == nil || cfg.Pool == nil {
-		return ErrInvalidConfig
-	}
-	baseURL, err := parseBaseTargetURL(cfg.BaseTargetURL)
+	cfg, baseURL, err := prepareConfig(cfg)
 	if err != nil {
 		return err
 	}
-	if cfg.Addr == "" {
-		cfg.Addr = defaultAddr
-	}
-	if cfg.WorkerCount <= 0 {
-		cfg.WorkerCount = 1
-	}
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	if strings.TrimSpace(cfg.DefaultSelector) == "" {
-		cfg.DefaultSelector = defaultSelector
-	}
-	if cfg.DefaultWaitTimeout < 0 {
-		return ErrInvalidConfig
-	}
 
-	log.Printf("server starting addr=%s baseTargetURL=%s workers=%d defaultSelector=%s defaultWaitTimeout=%s", cfg.Addr, baseURL.String(), cfg.WorkerCount, cfg.DefaultSelector, cfg.DefaultWaitTimeout)
+	poolMinSize, poolMaxSize := 0, 0
+	if cfg.Pool != nil {
+		poolMinSize, poolMaxSize = cfg.Pool.MinSize(), cfg.Pool.MaxSize()
+	}
+	log.Printf("server starting addr=%s baseTargetURL=%s workers=%d poolMinSize=%d poolMaxSize=%d defaultSelector=%s defaultWaitTimeout=%s renderer=%s", cfg.Addr, baseURL.String(), cfg.WorkerCount, poolMinSize, poolMaxSize, cfg.DefaultSelector, cfg.DefaultWaitTimeout, cfg.DefaultRenderer)
 
 	// launch worker goroutines
 	workerCtx, cancelWorkers := context.WithCancel(ctx)
 	defer cancelWorkers()
 
 	for i := 0; i < cfg.WorkerCount; i++ {
-		go workerLoop(workerCtx, i+1, cfg.Queue, cfg.Pool, transformers)
+		go workerLoop(workerCtx, i+1, cfg, transformers)
 	}
 
 	// launch HTTP server
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		handleRender(w, r, cfg.Queue, baseURL, cfg.DefaultSelector, cfg.DefaultWaitTimeout)
-	})
-
 	server := &http.Server{
 		Addr:    cfg.Addr,
-		Handler: mux,
+		Handler: newHandler(cfg, baseURL),
 	}
 
 	errCh := make(chan error, 1)
@@ -110,7 +267,108 @@ func Run(ctx context.Context, cfg Config, transformers []transformer.Transformer
 	}
 }
 
-func handleRender(w http.ResponseWriter, r *http.Request, queue *task.TaskQueue, baseURL *url.URL, defaultSelectorValue string, defaultWaitTimeout time.Duration) {
+// prepareConfig validates cfg and fills in defaults. It returns the parsed
+// base target URL.
+func prepareConfig(cfg Config) (Config, *url.URL, error) {
+	if cfg.Queue == nil || (cfg.Pool == nil && cfg.Renderers == nil) {
+		return cfg, nil, ErrInvalidConfig
+	}
+	baseURL, err := parseBaseTargetURL(cfg.BaseTargetURL)
+	if err != nil {
+		return cfg, nil, err
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = defaultAddr
+	}
+	if cfg.WorkerCount <= 0 {
+		cfg.WorkerCount = 1
+	}
+	if strings.TrimSpace(cfg.DefaultSelector) == "" {
+		cfg.DefaultSelector = defaultSelector
+	}
+	if cfg.DefaultWaitTimeout < 0 {
+		return cfg, nil, ErrInvalidConfig
+	}
+	if cfg.Devices == nil {
+		cfg.Devices = prerender.DefaultDevices()
+	}
+	if _, ok := cfg.Devices[cfg.DefaultDevice]; cfg.DefaultDevice != "" && !ok {
+		return cfg, nil, ErrInvalidConfig
+	}
+	for _, script := range cfg.Scripts {
+		if err := validatePatterns(script.Paths); err != nil {
+			return cfg, nil, err
+		}
+	}
+	if cfg.Renderers == nil {
+		cfg.Renderers = prerender.NewRenderers(cfg.Pool, nil)
+	}
+	if cfg.DefaultRenderer == "" {
+		cfg.DefaultRenderer = prerender.RendererChrome
+	}
+	if _, ok := cfg.Renderers[cfg.DefaultRenderer]; !ok {
+		return cfg, nil, fmt.Errorf("%w: unknown renderer %q", ErrInvalidConfig, cfg.DefaultRenderer)
+	}
+	for _, route := range cfg.Routes {
+		if err := validatePatterns(route.Paths); err != nil {
+			return cfg, nil, err
+		}
+		if err := (prerender.Options{Actions: route.Actions, AutoScroll: route.AutoScroll, WaitResponse: route.WaitResponse}).Validate(); err != nil {
+			return cfg, nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		if _, ok := cfg.Renderers[route.Renderer]; route.Renderer != "" && !ok {
+			return cfg, nil, fmt.Errorf("%w: unknown renderer %q", ErrInvalidConfig, route.Renderer)
+		}
+	}
+	// canonicalize a copy, as cfg shares the slice with the caller
+	cfg.ForwardHeaders = slices.Clone(cfg.ForwardHeaders)
+	for i, name := range cfg.ForwardHeaders {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if slices.Contains(unforwardableHeaders, name) || strings.HasPrefix(name, "X-Render-") {
+			return cfg, nil, fmt.Errorf("%w: header %q cannot be forwarded", ErrInvalidConfig, name)
+		}
+		cfg.ForwardHeaders[i] = name
+	}
+	if cfg.HARDir != "" {
+		if info, err := os.Stat(cfg.HARDir); err != nil || !info.IsDir() {
+			return cfg, nil, fmt.Errorf("%w: har dir %q is not a directory", ErrInvalidConfig, cfg.HARDir)
+		}
+	}
+	defaults := prerender.Options{Locale: cfg.DefaultLocale, Timezone: cfg.DefaultTimezone, Geolocation: cfg.DefaultGeolocation}
+	if err := defaults.Validate(); err != nil {
+		return cfg, nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	return cfg, baseURL, nil
+}
+
+// newHandler routes the stats and HAR endpoints and renders everything else.
+func newHandler(cfg Config, baseURL *url.URL) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statsPath, func(w http.ResponseWriter, r *http.Request) {
+		handleStats(w, r, cfg.Pool)
+	})
+	mux.Handle(harPath+"/", http.StripPrefix(harPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.HAREndpoint {
+			http.Error(w, "har endpoint disabled", http.StatusNotFound)
+			return
+		}
+		handleRender(w, r, cfg, baseURL, modeHAR)
+	})))
+	mux.Handle(diffPath+"/", http.StripPrefix(diffPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.DiffEndpoint {
+			http.Error(w, "diff endpoint disabled", http.StatusNotFound)
+			return
+		}
+		handleRender(w, r, cfg, baseURL, modeDiff)
+	})))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		handleRender(w, r, cfg, baseURL, modeOutput)
+	})
+	return mux
+}
+
+func handleRender(w http.ResponseWriter, r *http.Request, cfg Config, baseURL *url.URL, mode renderMode) {
 	start := time.Now()
 	// only proxy GET requests
 	if r.Method != http.MethodGet {
@@ -119,8 +377,11 @@ func handleRender(w http.ResponseWriter, r *http.Request, queue *task.TaskQueue,
 		return
 	}
 
+	// the output format may be given as a query parameter, which is not forwarded
+	reqURL, formatValue := stripQueryParam(r.URL, formatParam)
+
 	// from 'this/path?query' to 'target/path?query'
-	targetURL, err := buildTargetURL(baseURL, r.URL)
+	targetURL, err := buildTargetURL(baseURL, reqURL)
 	if err != nil {
 		log.Printf("invalid target url path=%s query=%s err=%v", r.URL.Path, r.URL.RawQuery, err)
 		http.Error(w, fmt.Sprintf("invalid target url: %v", err), http.StatusBadRequest)
@@ -130,7 +391,7 @@ func handleRender(w http.ResponseWriter, r *http.Request, queue *task.TaskQueue,
 	// read selector and wait from headers
 	selector := strings.TrimSpace(r.Header.Get(selectorHeader))
 	if selector == "" {
-		selector = defaultSelectorValue
+		selector = cfg.DefaultSelector
 	}
 
 	wait, err := parseWaitHeaders(r)
@@ -140,19 +401,85 @@ func handleRender(w http.ResponseWriter, r *http.Request, queue *task.TaskQueue,
 		return
 	}
 
-	log.Printf("request path=%s query=%s target=%s selector=%s wait=%s waitTimeout=%s remote=%s", r.URL.Path, r.URL.RawQuery, targetURL, selector, wait, defaultWaitTimeout, r.RemoteAddr)
+	if value := strings.TrimSpace(r.Header.Get(debugHeader)); value != "" && mode == modeOutput {
+		debug, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("invalid debug header path=%s err=%v", r.URL.Path, err)
+			http.Error(w, fmt.Sprintf("invalid %s: %v", debugHeader, err), http.StatusBadRequest)
+			return
+		}
+		if debug {
+			mode = modeDebug
+		}
+	}
+
+	options, err := parseRenderOptions(r, formatValue, cfg)
+	if err != nil {
+		log.Printf("invalid render options path=%s err=%v", r.URL.Path, err)
+		http.Error(w, fmt.Sprintf("invalid render options: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	deviceName, device, err := selectDevice(r, cfg)
+	if err != nil {
+		log.Printf("invalid device path=%s err=%v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// a device the request did not ask for is a browser default, so http
+	// and auto routes can still fetch without a browser
+	if strings.TrimSpace(r.Header.Get(deviceHeader)) != "" {
+		options.Device = device
+	} else {
+		options.Defaults.Device = device
+	}
+	for _, script := range cfg.Scripts {
+		if matchPaths(script.Paths, r.URL.Path) {
+			options.Scripts = append(options.Scripts, script.Source)
+		}
+	}
+
+	if err := parseActions(r, cfg, options); err != nil {
+		log.Printf("invalid actions path=%s err=%v", r.URL.Path, err)
+		http.Error(w, fmt.Sprintf("invalid render options: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := parseLocaleOptions(r, cfg, options); err != nil {
+		log.Printf("invalid locale options path=%s err=%v", r.URL.Path, err)
+		http.Error(w, fmt.Sprintf("invalid render options: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := parseExtras(r, cfg, targetURL, options); err != nil {
+		log.Printf("invalid extra headers or cookies path=%s err=%v", r.URL.Path, err)
+		http.Error(w, fmt.Sprintf("invalid render options: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	options.RecordHAR = mode == modeHAR
+	renderer := selectRenderer(r, cfg)
+
+	log.Printf("request path=%s query=%s target=%s selector=%s wait=%s waitTimeout=%s format=%s renderer=%s device=%s locale=%s timezone=%s extraHeaders=%d cookies=%d scripts=%d actions=%d remote=%s", r.URL.Path, r.URL.RawQuery, targetURL, selector, wait, cfg.DefaultWaitTimeout, options.Format, renderer, deviceName, options.Locale, cmp.Or(options.Timezone, options.Defaults.Timezone), len(options.Headers), len(options.Cookies), len(options.Scripts), len(options.Actions), r.RemoteAddr)
 
 	// publish task
-	resultCh := make(chan task.Result, 1)
 	taskItem := task.Task{
 		TargetURL:     targetURL,
 		Wait:          wait,
-		WaitTimeout:   defaultWaitTimeout,
+		WaitTimeout:   cfg.DefaultWaitTimeout,
 		QuerySelector: selector,
-		ResultCh:      resultCh,
+		Options:       options,
+		Renderer:      renderer,
+		Enqueued:      time.Now(),
+	}
+	if mode == modeDiff {
+		handleDiff(w, r, cfg, taskItem, start)
+		return
 	}
+	resultCh := make(chan task.Result, 1)
+	taskItem.ResultCh = resultCh
 
-	if err := queue.Enqueue(taskItem); err != nil {
+	if err := cfg.Queue.Enqueue(taskItem); err != nil {
 		log.Printf("enqueue failed target=%s err=%v", targetURL, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -161,14 +488,35 @@ func handleRender(w http.ResponseWriter, r *http.Request, queue *task.TaskQueue,
 	select {
 	// request done
 	case result := <-resultCh:
+		w.Header().Set("Server-Timing", serverTiming(result.Timings, time.Since(start)))
+		switch mode {
+		case modeDebug:
+			writeDebug(w, targetURL, result)
+			log.Printf("render debug target=%s err=%v duration=%s", targetURL, result.Err, time.Since(start))
+			return
+		case modeHAR:
+			if result.HAR == nil {
+				log.Printf("render failed target=%s err=%v duration=%s", targetURL, result.Err, time.Since(start))
+				http.Error(w, fmt.Sprintf("no har recorded: %v", result.Err), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, targetURL, result.HAR)
+			log.Printf("render har target=%s err=%v duration=%s", targetURL, result.Err, time.Since(start))
+			return
+		}
 		if result.Err != nil {
 			log.Printf("render failed target=%s err=%v duration=%s", targetURL, result.Err, time.Since(start))
 			http.Error(w, result.Err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, _ = w.Write([]byte(result.HTML))
-		log.Printf("render ok target=%s bytes=%d duration=%s", targetURL, len(result.HTML), time.Since(start))
+		body := result.Data
+		if body == nil {
+			body = []byte(result.HTML)
+		}
+		w.Header().Set("Content-Type", result.ContentType)
+		w.Header().Set("Vary", strings.Join(slices.Concat(varyHeaders, cfg.ForwardHeaders), ", "))
+		_, _ = w.Write(body)
+		log.Printf("render ok target=%s format=%s bytes=%d duration=%s", targetURL, options.Format, len(body), time.Since(start))
 	// canceled
 	case <-r.Context().Done():
 		log.Printf("request canceled target=%s err=%v duration=%s", targetURL, r.Context().Err(), time.Since(start))
@@ -176,6 +524,82 @@ func handleRender(w http.ResponseWriter, r *http.Request, queue *task.TaskQueue,
 	}
 }
 
+// debugResponse is the JSON body returned in debug mode.
+type debugResponse struct {
+	TargetURL   string                `json:"target_url"`
+	ContentType string                `json:"content_type,omitempty"`
+	Bytes       int                   `json:"bytes"`
+	HTML        string                `json:"html,omitempty"`
+	Renderer    string                `json:"renderer,omitempty"`
+	Error       string                `json:"error,omitempty"`
+	Diagnostics prerender.Diagnostics `json:"diagnostics"`
+}
+
+// writeDebug reports a render with its diagnostics as JSON. Binary output is
+// left out; only its size is reported.
+func writeDebug(w http.ResponseWriter, targetURL string, result task.Result) {
+	response := debugResponse{
+		TargetURL:   targetURL,
+		ContentType: result.ContentType,
+		Bytes:       len(result.HTML) + len(result.Data),
+		HTML:        result.HTML,
+		Renderer:    result.Renderer,
+		Diagnostics: result.Diagnostics,
+	}
+	status := http.StatusOK
+	if result.Err != nil {
+		response.Error = result.Err.Error()
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("debug encode failed target=%s err=%v", targetURL, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, targetURL string, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("json encode failed target=%s err=%v", targetURL, err)
+	}
+}
+
+// saveHAR writes har into dir, named after the render time and target. The
+// file is only readable by the owner.
+func saveHAR(dir, targetURL string, har *prerender.HAR) (string, error) {
+	name := targetURL
+	if u, err := url.Parse(targetURL); err == nil {
+		name = u.Host + u.Path
+	}
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	file := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000Z")+"-"+name+".har")
+
+	data, err := json.Marshal(har)
+	if err != nil {
+		return "", err
+	}
+	return file, os.WriteFile(file, data, 0o600)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request, pool *browser.Pool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if pool == nil {
+		http.Error(w, "no browser pool", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pool.Stats()); err != nil {
+		log.Printf("stats encode failed err=%v", err)
+	}
+}
+
 func parseWaitHeaders(r *http.Request) (time.Duration, error) {
 	if waitValue := strings.TrimSpace(r.Header.Get(waitHeader)); waitValue != "" {
 		return time.ParseDuration(waitValue)
@@ -190,6 +614,309 @@ func parseWaitHeaders(r *http.Request) (time.Duration, error) {
 	return 0, nil
 }
 
+// parseRenderOptions reads the output format and its options from the request
+// headers. formatValue, taken from the query string, overrides the format header.
+// The shadow DOM and frame inlining defaults come from cfg.
+func parseRenderOptions(r *http.Request, formatValue string, cfg Config) (*prerender.Options, error) {
+	if formatValue == "" {
+		formatValue = r.Header.Get(formatHeader)
+	}
+	format, err := prerender.ParseFormat(strings.ToLower(strings.TrimSpace(formatValue)))
+	if err != nil {
+		return nil, err
+	}
+
+	// the configured shadow DOM and frame inlining only apply as browser
+	// defaults unless the request turns them on itself
+	options := &prerender.Options{Format: format}
+	if value := strings.TrimSpace(r.Header.Get(shadowDOMHeader)); value != "" {
+		options.ShadowDOM, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", shadowDOMHeader, err)
+		}
+	} else {
+		options.Defaults.ShadowDOM = cfg.ShadowDOM
+	}
+	if value := strings.TrimSpace(r.Header.Get(javaScriptHeader)); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", javaScriptHeader, err)
+		}
+		options.DisableJavaScript = !enabled
+	}
+	if value := strings.TrimSpace(r.Header.Get(framesHeader)); value != "" {
+		inline, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", framesHeader, err)
+		}
+		if inline {
+			options.InlineFrames = cmp.Or(cfg.InlineFrames, &prerender.FrameInlining{})
+		}
+	} else {
+		options.Defaults.InlineFrames = cfg.InlineFrames
+	}
+	if fullPage := strings.TrimSpace(r.Header.Get(fullPageHeader)); fullPage != "" {
+		options.Screenshot.FullPage, err = strconv.ParseBool(fullPage)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fullPageHeader, err)
+		}
+	}
+	options.Screenshot.Selector = strings.TrimSpace(r.Header.Get(clipHeader))
+	if quality := strings.TrimSpace(r.Header.Get(qualityHeader)); quality != "" {
+		options.Screenshot.Quality, err = strconv.Atoi(quality)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", qualityHeader, err)
+		}
+	}
+	if pdfOptions := strings.TrimSpace(r.Header.Get(pdfHeader)); pdfOptions != "" {
+		decoder := json.NewDecoder(strings.NewReader(pdfOptions))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&options.PDF); err != nil {
+			return nil, fmt.Errorf("%s: %w", pdfHeader, err)
+		}
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// parseLocaleOptions fills the locale, Accept-Language, timezone and
+// geolocation of options from the request headers, falling back to the
+// configured defaults. The timezone and geolocation defaults need a browser,
+// so they are set as browser defaults.
+func parseLocaleOptions(r *http.Request, cfg Config, options *prerender.Options) error {
+	options.Locale = headerOrDefault(r, localeHeader, cfg.DefaultLocale)
+	options.AcceptLanguage = strings.TrimSpace(r.Header.Get(languageHeader))
+	options.Timezone = strings.TrimSpace(r.Header.Get(timezoneHeader))
+	if options.Timezone == "" {
+		options.Defaults.Timezone = cfg.DefaultTimezone
+	}
+	if geo := strings.TrimSpace(r.Header.Get(geoHeader)); geo != "" {
+		var err error
+		if options.Geolocation, err = prerender.ParseGeolocation(geo); err != nil {
+			return fmt.Errorf("%s: %w", geoHeader, err)
+		}
+	} else {
+		options.Defaults.Geolocation = cfg.DefaultGeolocation
+	}
+	return options.Validate()
+}
+
+// selectRenderer returns the renderer of the last route matching the request
+// path that sets one, or the default renderer.
+func selectRenderer(r *http.Request, cfg Config) string {
+	renderer := cfg.DefaultRenderer
+	for _, route := range cfg.Routes {
+		if route.Renderer != "" && matchPaths(route.Paths, r.URL.Path) {
+			renderer = route.Renderer
+		}
+	}
+	return renderer
+}
+
+// parseActions collects the auto-scroll settings, response waits and actions
+// of the routes matching the request path, followed by the actions given in
+// the request if those are allowed. The auto-scroll header turns route
+// auto-scroll on or off, and the response header replaces the route's wait.
+func parseActions(r *http.Request, cfg Config, options *prerender.Options) error {
+	for _, route := range cfg.Routes {
+		if matchPaths(route.Paths, r.URL.Path) {
+			options.Actions = append(options.Actions, route.Actions...)
+			if route.AutoScroll != nil {
+				options.AutoScroll = route.AutoScroll
+			}
+			if route.WaitResponse != nil {
+				options.WaitResponse = route.WaitResponse
+			}
+		}
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(responseHeader)); value != "" {
+		wait, err := prerender.ParseResponseWait(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", responseHeader, err)
+		}
+		options.WaitResponse = &wait
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(autoScrollHeader)); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", autoScrollHeader, err)
+		}
+		switch {
+		case !enabled:
+			options.AutoScroll = nil
+		case options.AutoScroll == nil:
+			options.AutoScroll = &prerender.AutoScroll{}
+		}
+	}
+
+	raw := strings.TrimSpace(r.Header.Get(actionsHeader))
+	if raw == "" {
+		return nil
+	}
+	if !cfg.AllowRequestActions {
+		return fmt.Errorf("%s: request actions are disabled", actionsHeader)
+	}
+	var actions []prerender.Action
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&actions); err != nil {
+		return fmt.Errorf("%s: %w", actionsHeader, err)
+	}
+	options.Actions = append(options.Actions, actions...)
+	return options.Validate()
+}
+
+// parseExtras merges the headers and cookies configured for the target host
+// with the allowlisted ones given in the request. Request values win.
+func parseExtras(r *http.Request, cfg Config, targetURL string, options *prerender.Options) error {
+	if host, ok := hostExtras(cfg.Hosts, targetURL); ok {
+		for name, value := range host.Headers {
+			if options.Headers == nil {
+				options.Headers = make(map[string]string)
+			}
+			options.Headers[http.CanonicalHeaderKey(name)] = value
+		}
+		options.Cookies = append(options.Cookies, host.Cookies...)
+	}
+
+	forwardHeaders(r, cfg.ForwardHeaders, options)
+
+	if raw := strings.TrimSpace(r.Header.Get(extraHeaders)); raw != "" {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+			return fmt.Errorf("%s: %w", extraHeaders, err)
+		}
+		for name, value := range headers {
+			name = http.CanonicalHeaderKey(name)
+			if !slices.ContainsFunc(cfg.HeaderAllowlist, func(allowed string) bool { return strings.EqualFold(allowed, name) }) {
+				return fmt.Errorf("%s: header %q is not allowed", extraHeaders, name)
+			}
+			if options.Headers == nil {
+				options.Headers = make(map[string]string)
+			}
+			options.Headers[name] = value
+		}
+	}
+
+	if raw := strings.TrimSpace(r.Header.Get(cookiesHeader)); raw != "" {
+		cookies, err := http.ParseCookie(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cookiesHeader, err)
+		}
+		for _, c := range cookies {
+			if !slices.Contains(cfg.CookieAllowlist, c.Name) {
+				return fmt.Errorf("%s: cookie %q is not allowed", cookiesHeader, c.Name)
+			}
+			options.Cookies = slices.DeleteFunc(options.Cookies, func(existing prerender.Cookie) bool { return existing.Name == c.Name })
+			options.Cookies = append(options.Cookies, prerender.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return options.Validate()
+}
+
+// forwardHeaders copies the listed client headers into options, replacing
+// host defaults of the same name.
+func forwardHeaders(r *http.Request, names []string, options *prerender.Options) {
+	for _, name := range names {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if name == "Cookie" {
+			for _, c := range r.Cookies() {
+				options.Cookies = slices.DeleteFunc(options.Cookies, func(existing prerender.Cookie) bool { return existing.Name == c.Name })
+				options.Cookies = append(options.Cookies, prerender.Cookie{Name: c.Name, Value: c.Value})
+			}
+			continue
+		}
+		if options.Headers == nil {
+			options.Headers = make(map[string]string)
+		}
+		options.Headers[name] = strings.Join(values, ", ")
+	}
+}
+
+// hostExtras looks up the extras for the target's host:port, then its host name.
+func hostExtras(hosts map[string]TargetExtras, targetURL string) (TargetExtras, bool) {
+	if len(hosts) == 0 {
+		return TargetExtras{}, false
+	}
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return TargetExtras{}, false
+	}
+	if extras, ok := hosts[strings.ToLower(u.Host)]; ok {
+		return extras, true
+	}
+	extras, ok := hosts[strings.ToLower(u.Hostname())]
+	return extras, ok
+}
+
+func headerOrDefault(r *http.Request, header, fallback string) string {
+	if value := strings.TrimSpace(r.Header.Get(header)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// selectDevice resolves the device profile for a request: the device header,
+// then the client's User-Agent if auto-selection is enabled, then the default.
+// It returns a nil device when none applies.
+func selectDevice(r *http.Request, cfg Config) (string, *prerender.Device, error) {
+	name := strings.ToLower(strings.TrimSpace(r.Header.Get(deviceHeader)))
+	if name == "" && cfg.AutoSelectDevice && r.UserAgent() != "" {
+		if auto := prerender.DeviceForUserAgent(r.UserAgent()); hasDevice(cfg, auto) {
+			name = auto
+		}
+	}
+	if name == "" {
+		name = cfg.DefaultDevice
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+
+	device, ok := cfg.Devices[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown device %q", name)
+	}
+	return name, &device, nil
+}
+
+func hasDevice(cfg Config, name string) bool {
+	_, ok := cfg.Devices[name]
+	return ok
+}
+
+// stripQueryParam returns a copy of u without the query parameter key, and the
+// parameter's first value. The order of the remaining parameters is preserved.
+func stripQueryParam(u *url.URL, key string) (*url.URL, string) {
+	if u.RawQuery == "" {
+		return u, ""
+	}
+
+	var value string
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(rawKey); err == nil && name == key {
+			if value == "" {
+				value, _ = url.QueryUnescape(rawValue)
+			}
+			continue
+		}
+		kept = append(kept, pair)
+	}
+
+	stripped := *u
+	stripped.RawQuery = strings.Join(kept, "&")
+	return &stripped, value
+}
+
 func parseBaseTargetURL(raw string) (*url.URL, error) {
 	if strings.TrimSpace(raw) == "" {
 		return nil, ErrInvalidBaseTargetURL
@@ -220,7 +947,56 @@ func transformersToNames(transformers []transformer.Transformer) []string {
 	return names
 }
 
-func workerLoop(ctx context.Context, id int, queue *task.TaskQueue, pool *browser.Pool, transformers []transformer.Transformer) {
+// taskTimings lists the phases of a task in the order they ran, leaving out
+// phases that did not run. Page-measured values are listed last.
+func taskTimings(queueWait time.Duration, t prerender.Timings, transforms []transformer.Timing) []task.Timing {
+	timings := []task.Timing{
+		{Name: "queue", Duration: queueWait},
+		{Name: "acquire", Duration: t.Acquire},
+		{Name: "navigation", Duration: t.Navigation},
+		{Name: "response", Duration: t.ResponseWait},
+		{Name: "selector", Duration: t.SelectorWait},
+		{Name: "sleep", Duration: t.Sleep},
+		{Name: "autoscroll", Duration: t.AutoScroll},
+		{Name: "actions", Duration: t.Actions},
+		{Name: "capture", Duration: t.Capture},
+	}
+	for _, tt := range transforms {
+		timings = append(timings, task.Timing{Name: "transform-" + tt.Name, Duration: tt.Duration})
+	}
+	timings = append(timings,
+		task.Timing{Name: "dcl", Duration: t.DOMContentLoaded},
+		task.Timing{Name: "fcp", Duration: t.FCP},
+		task.Timing{Name: "lcp", Duration: t.LCP},
+	)
+	return slices.DeleteFunc(timings, func(timing task.Timing) bool { return timing.Duration <= 0 })
+}
+
+// serverTiming formats timings as a Server-Timing header value in milliseconds.
+func serverTiming(timings []task.Timing, total time.Duration) string {
+	parts := make([]string, 0, len(timings)+1)
+	for _, timing := range timings {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.1f", timing.Name, float64(timing.Duration)/float64(time.Millisecond)))
+	}
+	parts = append(parts, fmt.Sprintf("total;dur=%.1f", float64(total)/float64(time.Millisecond)))
+	return strings.Join(parts, ", ")
+}
+
+func formatTransformTimings(timings []transformer.Timing) string {
+	parts := make([]string, 0, len(timings))
+	for _, timing := range timings {
+		parts = append(parts, timing.Name+":"+timing.Duration.Round(time.Microsecond).String())
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// diagnosticCounts formats the error counts of a render for the logs.
+func diagnosticCounts(d prerender.Diagnostics) string {
+	return fmt.Sprintf("consoleErrors=%d exceptions=%d failedRequests=%d", d.ConsoleErrors(), len(d.Exceptions), len(d.FailedRequests))
+}
+
+func workerLoop(ctx context.Context, id int, cfg Config, transformers []transformer.Transformer) {
+	queue := cfg.Queue
 	log.Printf("worker started id=%d", id)
 	log.Printf("worker config id=%d transformers=%v", id, transformersToNames(transformers))
 	for {
@@ -237,13 +1013,48 @@ func workerLoop(ctx context.Context, id int, queue *task.TaskQueue, pool *browse
 
 		// request results in the browser and apply transformations
 		start := time.Now()
-		html, renderErr := prerender.RenderUntil(context.Background(), pool, item.TargetURL, item.Wait, item.QuerySelector, item.WaitTimeout)
+		var queueWait time.Duration
+		if !item.Enqueued.IsZero() {
+			queueWait = start.Sub(item.Enqueued)
+		}
+		var options prerender.Options
+		if item.Options != nil {
+			options = *item.Options
+		}
+		// limits and slow-render HARs only apply when the page runs in a browser
+		options.Defaults.Limits = cfg.ResourceLimits
+		if cfg.HARDir != "" {
+			options.Defaults.RecordHAR = true
+		}
+		renderer, ok := cfg.Renderers[item.Renderer]
+		if !ok {
+			renderer = cfg.Renderers[cfg.DefaultRenderer]
+		}
+		result, renderErr := renderer.Render(context.Background(), prerender.Request{
+			TargetURL:     item.TargetURL,
+			Wait:          item.Wait,
+			QuerySelector: item.QuerySelector,
+			WaitTimeout:   item.WaitTimeout,
+			Options:       options,
+		})
+		html := result.HTML
+		if duration := time.Since(start); cfg.HARDir != "" && result.HAR != nil && duration >= cfg.HARThreshold {
+			if file, err := saveHAR(cfg.HARDir, item.TargetURL, result.HAR); err != nil {
+				log.Printf("worker har save failed id=%d target=%s err=%v", id, item.TargetURL, err)
+			} else {
+				log.Printf("worker har saved id=%d target=%s file=%s duration=%s", id, item.TargetURL, file, duration)
+			}
+		}
+		log.Printf("worker page metrics id=%d target=%s %s", id, item.TargetURL, result.Metrics)
 		if errors.Is(renderErr, prerender.ErrWaitTimeout) {
 			log.Printf("worker wait timeout id=%d target=%s timeout=%s duration=%s", id, item.TargetURL, item.WaitTimeout, time.Since(start))
 			renderErr = nil
 		}
-		if renderErr == nil {
-			transformed, transformErr := transformer.ApplyAll(html, transformers...)
+		// transformers only apply to HTML documents
+		var transformTimings []transformer.Timing
+		if renderErr == nil && !options.Format.Binary() {
+			transformed, timings, transformErr := transformer.ApplyAllTimed(html, transformers...)
+			transformTimings = timings
 			if transformErr != nil {
 				renderErr = transformErr
 			} else {
@@ -251,15 +1062,22 @@ func workerLoop(ctx context.Context, id int, queue *task.TaskQueue, pool *browse
 			}
 		}
 
+		timings := taskTimings(queueWait, result.Timings, transformTimings)
+		log.Printf("worker timings id=%d target=%s queue=%s %s transformers=%s", id, item.TargetURL, queueWait.Round(time.Millisecond), result.Timings, formatTransformTimings(transformTimings))
+
 		// push results to the result channel if exists, and log the outcome
 		if item.ResultCh != nil {
-			item.ResultCh <- task.Result{HTML: html, Err: renderErr}
+			item.ResultCh <- task.Result{HTML: html, Data: result.Data, ContentType: result.ContentType, Diagnostics: result.Diagnostics, HAR: result.HAR, Timings: timings, Renderer: result.Renderer, Err: renderErr}
 			close(item.ResultCh)
 		}
 		if renderErr != nil {
-			log.Printf("worker render failed id=%d target=%s err=%v duration=%s", id, item.TargetURL, renderErr, time.Since(start))
+			log.Printf("worker render failed id=%d target=%s err=%v duration=%s %s", id, item.TargetURL, renderErr, time.Since(start), diagnosticCounts(result.Diagnostics))
 			continue
 		}
-		log.Printf("worker render ok id=%d target=%s bytes=%d duration=%s", id, item.TargetURL, len(html), time.Since(start))
+		poolSize := 0
+		if cfg.Pool != nil {
+			poolSize = cfg.Pool.Size()
+		}
+		log.Printf("worker render ok id=%d target=%s format=%s renderer=%s bytes=%d duration=%s poolSize=%d %s", id, item.TargetURL, options.Format, result.Renderer, len(html)+len(result.Data), time.Since(start), poolSize, diagnosticCounts(result.Diagnostics))
 	}
 }
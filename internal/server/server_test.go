@@ -0,0 +1,663 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IncorrectM/precrawl/internal/prerender"
+	"github.com/IncorrectM/precrawl/internal/prerender/prerendertest"
+	"github.com/IncorrectM/precrawl/internal/task"
+	"github.com/IncorrectM/precrawl/internal/transformer"
+)
+
+func TestServerTiming(t *testing.T) {
+	t.Parallel()
+
+	timings := taskTimings(
+		2*time.Millisecond,
+		prerender.Timings{Acquire: time.Millisecond, Navigation: 120 * time.Millisecond, Capture: 5 * time.Millisecond, FCP: 80 * time.Millisecond},
+		[]transformer.Timing{{Name: "ClassPruner", Duration: 1500 * time.Microsecond}},
+	)
+	got := serverTiming(timings, 130*time.Millisecond)
+	want := "queue;dur=2.0, acquire;dur=1.0, navigation;dur=120.0, capture;dur=5.0, transform-ClassPruner;dur=1.5, fcp;dur=80.0, total;dur=130.0"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParseRenderOptionsShadowDOM(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	options, err := parseRenderOptions(r, "", Config{ShadowDOM: true})
+	if err != nil || options.ShadowDOM || !options.WithBrowserDefaults().ShadowDOM {
+		t.Fatalf("expected shadow DOM as a browser default, got %+v, %v", options, err)
+	}
+
+	r.Header.Set(shadowDOMHeader, "false")
+	if options, err = parseRenderOptions(r, "", Config{ShadowDOM: true}); err != nil || options.WithBrowserDefaults().ShadowDOM {
+		t.Fatalf("expected header to turn shadow DOM off, got %+v, %v", options, err)
+	}
+
+	r.Header.Set(shadowDOMHeader, "maybe")
+	if _, err = parseRenderOptions(r, "", Config{}); err == nil {
+		t.Fatal("expected error for invalid shadow DOM header")
+	}
+}
+
+func TestParseRenderOptionsInlineFrames(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(framesHeader, "true")
+	options, err := parseRenderOptions(r, "", Config{})
+	if err != nil || options.InlineFrames == nil {
+		t.Fatalf("expected frame inlining with defaults, got %+v, %v", options, err)
+	}
+
+	r.Header.Set(framesHeader, "false")
+	options, err = parseRenderOptions(r, "", Config{InlineFrames: &prerender.FrameInlining{MaxDepth: 3}})
+	if err != nil || options.WithBrowserDefaults().InlineFrames != nil {
+		t.Fatalf("expected header to turn frame inlining off, got %+v, %v", options, err)
+	}
+
+	r.Header.Del(framesHeader)
+	options, err = parseRenderOptions(r, "", Config{InlineFrames: &prerender.FrameInlining{MaxDepth: 3}})
+	if err != nil || options.InlineFrames != nil || options.WithBrowserDefaults().InlineFrames.MaxDepth != 3 {
+		t.Fatalf("expected frame inlining as a browser default, got %+v, %v", options, err)
+	}
+}
+
+func TestSelectRenderer(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{
+		DefaultRenderer: prerender.RendererChrome,
+		Routes: []Route{
+			{Paths: []string{"/docs/*"}, Renderer: prerender.RendererAuto},
+			{Paths: []string{"/docs/static/*"}, Renderer: prerender.RendererHTTP},
+			{Paths: []string{"/docs/*"}},
+		},
+	}
+	// path.Match does not cross slashes, so /docs/* only matches one level
+	cases := map[string]string{
+		"/":                 prerender.RendererChrome,
+		"/docs/intro":       prerender.RendererAuto,
+		"/docs/static":      prerender.RendererAuto,
+		"/docs/static/page": prerender.RendererHTTP,
+		"/docs/a/b":         prerender.RendererChrome,
+	}
+	for requestPath, want := range cases {
+		if got := selectRenderer(httptest.NewRequest("GET", requestPath, nil), cfg); got != want {
+			t.Fatalf("path %s: expected %q, got %q", requestPath, want, got)
+		}
+	}
+}
+
+const testBaseURL = "http://target.test"
+
+// startServer runs the workers and handler for cfg against a fresh queue.
+func startServer(t *testing.T, cfg Config, transformers ...transformer.Transformer) *httptest.Server {
+	t.Helper()
+
+	cfg.Queue = task.NewQueue()
+	if cfg.BaseTargetURL == "" {
+		cfg.BaseTargetURL = testBaseURL
+	}
+	cfg, baseURL, err := prepareConfig(cfg)
+	if err != nil {
+		t.Fatalf("prepareConfig error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < cfg.WorkerCount; i++ {
+		go workerLoop(ctx, i+1, cfg, transformers)
+	}
+	server := httptest.NewServer(newHandler(cfg, baseURL))
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return server
+}
+
+func fakeConfig(renderer *prerendertest.Renderer) Config {
+	return Config{Renderers: map[string]prerender.Renderer{prerender.RendererChrome: renderer}}
+}
+
+func get(t *testing.T, url string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest error: %v", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body error: %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestHandleRender(t *testing.T) {
+	t.Parallel()
+
+	renderer := prerendertest.New(map[string]prerendertest.Page{
+		"/page?x=1": {HTML: `<html><body><div class="card">ok</div></body></html>`},
+	})
+	server := startServer(t, fakeConfig(renderer), transformer.NewClassPruner())
+
+	resp, body := get(t, server.URL+"/page?x=1", map[string]string{selectorHeader: "#app"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	if strings.Contains(body, "class=") || !strings.Contains(body, "ok") {
+		t.Fatalf("expected transformed html, got %q", body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != prerender.FormatHTML.ContentType() {
+		t.Fatalf("expected html content type, got %q", got)
+	}
+	if got := resp.Header.Get("Server-Timing"); !strings.Contains(got, "total;dur=") || !strings.Contains(got, "transform-ClassPruner") {
+		t.Fatalf("expected server timing, got %q", got)
+	}
+	if got := resp.Header.Get("Vary"); !strings.Contains(got, selectorHeader) {
+		t.Fatalf("expected Vary to list %s, got %q", selectorHeader, got)
+	}
+
+	requests := renderer.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 render, got %d", len(requests))
+	}
+	if requests[0].TargetURL != testBaseURL+"/page?x=1" || requests[0].QuerySelector != "#app" || requests[0].Format != prerender.FormatHTML {
+		t.Fatalf("unexpected render request: %+v", requests[0])
+	}
+}
+
+func TestHandleRenderForwardHeaders(t *testing.T) {
+	t.Parallel()
+
+	page := prerendertest.Page{HTML: "<html><body>ok</body></html>"}
+	renderer := prerendertest.New(map[string]prerendertest.Page{"/page": page})
+	forward := []string{"accept-language", " cookie "}
+	cfg := fakeConfig(renderer)
+	cfg.ForwardHeaders = forward
+	cfg.Hosts = map[string]TargetExtras{"target.test": {
+		Headers: map[string]string{"Accept-Language": "fr"},
+		Cookies: []prerender.Cookie{{Name: "session", Value: "host"}, {Name: "bypass", Value: "1"}},
+	}}
+	server := startServer(t, cfg)
+
+	resp, body := get(t, server.URL+"/page", map[string]string{
+		"Accept-Language": "de-DE",
+		"Cookie":          "session=client; theme=dark",
+		"Authorization":   "Bearer secret",
+		"User-Agent":      "client-agent",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	if forward[0] != "accept-language" || forward[1] != " cookie " {
+		t.Fatalf("expected caller's ForwardHeaders to be left alone, got %q", forward)
+	}
+	if got := resp.Header.Get("Vary"); !strings.HasSuffix(got, ", Accept-Language, Cookie") {
+		t.Fatalf("expected Vary to end with the forwarded headers, got %q", got)
+	}
+
+	req := renderer.Requests()[0]
+	want := map[string]string{"Accept-Language": "de-DE"}
+	if len(req.Headers) != len(want) || req.Headers["Accept-Language"] != want["Accept-Language"] {
+		t.Fatalf("expected headers %v, got %v", want, req.Headers)
+	}
+	wantCookies := []prerender.Cookie{{Name: "bypass", Value: "1"}, {Name: "session", Value: "client"}, {Name: "theme", Value: "dark"}}
+	if !slices.Equal(req.Cookies, wantCookies) {
+		t.Fatalf("expected cookies %+v, got %+v", wantCookies, req.Cookies)
+	}
+
+	// Authorization is forwarded only when listed
+	cfg.ForwardHeaders = []string{"Authorization"}
+	server = startServer(t, cfg)
+	if resp, body := get(t, server.URL+"/page", map[string]string{"Authorization": "Bearer secret"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	requests := renderer.Requests()
+	if got := requests[len(requests)-1].Headers["Authorization"]; got != "Bearer secret" {
+		t.Fatalf("expected listed Authorization to be forwarded, got %q", got)
+	}
+}
+
+func TestPrepareConfigForwardHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := fakeConfig(prerendertest.New(nil))
+	cfg.Queue = task.NewQueue()
+	cfg.BaseTargetURL = testBaseURL
+	for _, name := range []string{"Host", "connection", "X-Render-Selector"} {
+		cfg.ForwardHeaders = []string{name}
+		if _, _, err := prepareConfig(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected %s to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestHandleRenderScripts(t *testing.T) {
+	t.Parallel()
+
+	page := prerendertest.Page{HTML: "<html><body>ok</body></html>"}
+	renderer := prerendertest.New(map[string]prerendertest.Page{"/app/home": page, "/blog/post": page})
+	cfg := fakeConfig(renderer)
+	cfg.Scripts = []Script{
+		{Source: "global()"},
+		{Source: "app()", Paths: []string{"/app/*"}},
+		{Source: "posts()", Paths: []string{"/news/*", "/blog/*"}},
+		{Source: "later()"},
+	}
+	server := startServer(t, cfg)
+
+	cases := map[string][]string{
+		"/app/home":  {"global()", "app()", "later()"},
+		"/blog/post": {"global()", "posts()", "later()"},
+	}
+	for requestPath, want := range cases {
+		if resp, body := get(t, server.URL+requestPath, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("path %s: expected 200, got %d: %s", requestPath, resp.StatusCode, body)
+		}
+		requests := renderer.Requests()
+		if got := requests[len(requests)-1].Scripts; !slices.Equal(got, want) {
+			t.Fatalf("path %s: expected scripts %q, got %q", requestPath, want, got)
+		}
+	}
+}
+
+func TestHandleRenderOutcomes(t *testing.T) {
+	t.Parallel()
+
+	renderer := prerendertest.New(map[string]prerendertest.Page{
+		"/error":   {Err: errors.New("boom")},
+		"/timeout": {HTML: "<html><body>partial</body></html>", Err: prerender.ErrWaitTimeout},
+		"/missing": {HTML: "<html><body>not found</body></html>", Status: http.StatusNotFound},
+		"/image":   {Data: []byte("PNG"), ContentType: "image/png"},
+		"/archive": {Data: []byte("MIME-Version: 1.0")},
+	})
+	cfg := fakeConfig(renderer)
+	cfg.HAREndpoint = true
+	server := startServer(t, cfg, transformer.NewClassPruner())
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/error", http.StatusInternalServerError, "boom"},
+		{"/timeout", http.StatusOK, "partial"},
+		{"/missing", http.StatusOK, "not found"},
+		{"/unknown", http.StatusInternalServerError, prerendertest.ErrNoPage.Error()},
+		{"/image?" + formatParam + "=png", http.StatusOK, "PNG"},
+		{harPath + "/timeout", http.StatusInternalServerError, "no har recorded"},
+		{statsPath, http.StatusNotFound, "no browser pool"},
+	}
+	for _, c := range cases {
+		resp, body := get(t, server.URL+c.path, nil)
+		if resp.StatusCode != c.status || !strings.Contains(body, c.body) {
+			t.Fatalf("%s: expected %d with %q, got %d: %q", c.path, c.status, c.body, resp.StatusCode, body)
+		}
+	}
+
+	resp, body := get(t, server.URL+"/archive?"+formatParam+"=mhtml", nil)
+	if got := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || got != "application/x-mimearchive" {
+		t.Fatalf("expected an mhtml archive, got %d %q: %q", resp.StatusCode, got, body)
+	}
+
+	for _, req := range renderer.Requests() {
+		if strings.Contains(req.TargetURL, formatParam) {
+			t.Fatalf("expected format param to be stripped, got %s", req.TargetURL)
+		}
+	}
+
+	resp, err := http.Post(server.URL+"/page", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleRenderDebug(t *testing.T) {
+	t.Parallel()
+
+	renderer := prerendertest.New(map[string]prerendertest.Page{
+		"/missing": {HTML: "<html></html>", Status: http.StatusNotFound},
+	})
+	server := startServer(t, fakeConfig(renderer))
+
+	resp, body := get(t, server.URL+"/missing", map[string]string{debugHeader: "true"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var report debugResponse
+	if err := json.Unmarshal([]byte(body), &report); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if report.Renderer != prerendertest.Name || len(report.Diagnostics.FailedRequests) != 1 || report.Diagnostics.FailedRequests[0].Status != http.StatusNotFound {
+		t.Fatalf("unexpected debug report: %+v", report)
+	}
+}
+
+func TestHandleRenderBrowserDefaults(t *testing.T) {
+	t.Parallel()
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if strings.HasSuffix(r.URL.Path, "/spa") {
+			_, _ = w.Write([]byte(`<html><body><div id="root"></div></body></html>`))
+			return
+		}
+		_, _ = w.Write([]byte(`<html><body><div id="content">static</div></body></html>`))
+	}))
+	t.Cleanup(site.Close)
+
+	browser := prerendertest.New(map[string]prerendertest.Page{"/auto/spa": {HTML: "<html><body>browser</body></html>"}})
+	fetch := &prerender.HTTPRenderer{Client: site.Client()}
+	limits := prerender.ResourceLimits{MaxDOMNodes: 1000}
+	server := startServer(t, Config{
+		BaseTargetURL: site.URL,
+		Renderers: map[string]prerender.Renderer{
+			prerender.RendererChrome: browser,
+			prerender.RendererHTTP:   fetch,
+			prerender.RendererAuto:   &prerender.AutoRenderer{HTTP: fetch, Browser: browser},
+		},
+		DefaultRenderer: prerender.RendererHTTP,
+		Routes:          []Route{{Paths: []string{"/auto/*"}, Renderer: prerender.RendererAuto}},
+		ResourceLimits:  limits,
+		HARDir:          t.TempDir(),
+		DefaultDevice:   prerender.DeviceMobile,
+		DefaultTimezone: "Europe/Berlin",
+		ShadowDOM:       true,
+	})
+
+	// server-wide defaults that need a browser must not fail http renders or
+	// keep auto from serving the fetched document
+	for _, path := range []string{"/static", "/auto/static"} {
+		resp, body := get(t, server.URL+path, map[string]string{selectorHeader: "#content", debugHeader: "true"})
+		var report debugResponse
+		if err := json.Unmarshal([]byte(body), &report); err != nil {
+			t.Fatalf("%s: Unmarshal error: %v: %s", path, err, body)
+		}
+		if resp.StatusCode != http.StatusOK || report.Error != "" || report.Renderer != prerender.RendererHTTP || !strings.Contains(report.HTML, "static") {
+			t.Fatalf("%s: expected an http render, got %d: %+v", path, resp.StatusCode, report)
+		}
+	}
+	if requests := browser.Requests(); len(requests) != 0 {
+		t.Fatalf("expected no browser renders, got %d", len(requests))
+	}
+
+	// requests the browser renders still get them
+	if resp, body := get(t, server.URL+"/auto/spa", map[string]string{selectorHeader: "#content"}); resp.StatusCode != http.StatusOK || !strings.Contains(body, "browser") {
+		t.Fatalf("expected the browser fallback, got %d: %s", resp.StatusCode, body)
+	}
+	requests := browser.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 browser render, got %d", len(requests))
+	}
+	got := requests[0].Options
+	if got.Limits != limits || !got.RecordHAR || got.Device == nil || !got.Device.Mobile || got.Timezone != "Europe/Berlin" || !got.ShadowDOM {
+		t.Fatalf("expected the browser defaults to apply, got %+v", got)
+	}
+}
+
+func TestHandleHAR(t *testing.T) {
+	t.Parallel()
+
+	har := &prerender.HAR{Log: prerender.HARLog{Version: "1.2"}}
+	renderer := prerendertest.New(map[string]prerendertest.Page{"/page": {HTML: "<html></html>", HAR: har}})
+	cfg := fakeConfig(renderer)
+
+	disabled := startServer(t, cfg)
+	if resp, body := get(t, disabled.URL+harPath+"/page", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 with the endpoint disabled, got %d: %s", resp.StatusCode, body)
+	}
+	if n := len(renderer.Requests()); n != 0 {
+		t.Fatalf("expected no render with the endpoint disabled, got %d", n)
+	}
+
+	cfg.HAREndpoint = true
+	enabled := startServer(t, cfg)
+	resp, body := get(t, enabled.URL+harPath+"/page", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var got prerender.HAR
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.Log.Version != "1.2" {
+		t.Fatalf("expected the recorded HAR, got %s (%v)", body, err)
+	}
+	if requests := renderer.Requests(); len(requests) != 1 || !requests[0].RecordHAR {
+		t.Fatalf("expected one render recording a HAR, got %+v", requests)
+	}
+}
+
+func TestSaveHAR(t *testing.T) {
+	t.Parallel()
+
+	file, err := saveHAR(t.TempDir(), "https://target.test/a/b?c=d", &prerender.HAR{})
+	if err != nil {
+		t.Fatalf("saveHAR error: %v", err)
+	}
+	if !strings.HasSuffix(file, "-target.test_a_b.har") {
+		t.Fatalf("unexpected file name %s", file)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("expected mode 0600, got %o", perm)
+	}
+}
+
+func TestHandleRenderCanceled(t *testing.T) {
+	t.Parallel()
+
+	renderer := prerendertest.New(map[string]prerendertest.Page{
+		"/slow": {HTML: "<html></html>", Delay: time.Second},
+	})
+	cfg, baseURL, err := prepareConfig(Config{Queue: task.NewQueue(), BaseTargetURL: testBaseURL, Renderers: fakeConfig(renderer).Renderers})
+	if err != nil {
+		t.Fatalf("prepareConfig error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// no worker runs, so the request only ends through cancellation
+	recorder := httptest.NewRecorder()
+	handleRender(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx), cfg, baseURL, modeOutput)
+	if recorder.Code != http.StatusRequestTimeout {
+		t.Fatalf("expected 408, got %d", recorder.Code)
+	}
+	if cfg.Queue.Len() != 1 {
+		t.Fatalf("expected the task to stay queued, got %d", cfg.Queue.Len())
+	}
+}
+
+func TestWorkerLoopSelectsRenderer(t *testing.T) {
+	t.Parallel()
+
+	chrome := prerendertest.New(map[string]prerendertest.Page{"/app": {HTML: "<html>chrome</html>"}})
+	fetch := prerendertest.New(map[string]prerendertest.Page{"/static/a": {HTML: "<html>http</html>"}})
+	server := startServer(t, Config{
+		Renderers: map[string]prerender.Renderer{prerender.RendererChrome: chrome, prerender.RendererHTTP: fetch},
+		Routes:    []Route{{Paths: []string{"/static/*"}, Renderer: prerender.RendererHTTP}},
+	})
+
+	if _, body := get(t, server.URL+"/app", nil); !strings.Contains(body, "chrome") {
+		t.Fatalf("expected default renderer, got %q", body)
+	}
+	if _, body := get(t, server.URL+"/static/a", nil); !strings.Contains(body, "http") {
+		t.Fatalf("expected route renderer, got %q", body)
+	}
+	if len(chrome.Requests()) != 1 || len(fetch.Requests()) != 1 {
+		t.Fatalf("expected one render per renderer, got %d and %d", len(chrome.Requests()), len(fetch.Requests()))
+	}
+}
+
+func TestWorkerLoopResult(t *testing.T) {
+	t.Parallel()
+
+	renderer := prerendertest.New(map[string]prerendertest.Page{
+		"/page": {HTML: `<html><body class="x">late</body></html>`, Delay: 10 * time.Millisecond, Err: prerender.ErrWaitTimeout},
+	})
+	cfg, _, err := prepareConfig(Config{
+		Queue:          task.NewQueue(),
+		BaseTargetURL:  testBaseURL,
+		Renderers:      fakeConfig(renderer).Renderers,
+		ResourceLimits: prerender.ResourceLimits{MaxDOMNodes: 100},
+	})
+	if err != nil {
+		t.Fatalf("prepareConfig error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go workerLoop(ctx, 1, cfg, []transformer.Transformer{transformer.NewClassPruner()})
+
+	resultCh := make(chan task.Result, 1)
+	if err := cfg.Queue.Enqueue(task.Task{
+		TargetURL:     testBaseURL + "/page",
+		QuerySelector: "body",
+		WaitTimeout:   time.Second,
+		Enqueued:      time.Now(),
+		ResultCh:      resultCh,
+	}); err != nil {
+		t.Fatalf("Enqueue error: %v", err)
+	}
+
+	select {
+	case result := <-resultCh:
+		// a selector timeout still delivers the transformed document
+		if result.Err != nil || result.HTML != "<html><head></head><body>late</body></html>" || result.Renderer != prerendertest.Name {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		if len(result.Timings) == 0 || result.Timings[0].Name != "queue" {
+			t.Fatalf("expected queue timing first, got %+v", result.Timings)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for result")
+	}
+
+	requests := renderer.Requests()
+	if len(requests) != 1 || requests[0].Limits.MaxDOMNodes != 100 || requests[0].WaitTimeout != time.Second {
+		t.Fatalf("expected limits and wait timeout on the render, got %+v", requests)
+	}
+}
+
+func TestParseRenderOptionsJavaScript(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(javaScriptHeader, "false")
+	options, err := parseRenderOptions(r, "", Config{})
+	if err != nil || !options.DisableJavaScript {
+		t.Fatalf("expected JavaScript to be disabled, got %+v, %v", options, err)
+	}
+
+	r.Header.Set(javaScriptHeader, "off")
+	if _, err = parseRenderOptions(r, "", Config{}); err == nil {
+		t.Fatal("expected error for invalid JavaScript header")
+	}
+}
+
+func TestHandleDiff(t *testing.T) {
+	t.Parallel()
+
+	renderer := prerendertest.New(map[string]prerendertest.Page{
+		"/product": {
+			HTML: `<html><head><title>Shoe</title><meta name="description" content="A shoe"></head><body>` +
+				`<div id="app"><h1>Red shoe</h1><p>In stock now</p><p><a href="/cart">Cart</a></p><p><a href="/">Home</a></p></div></body></html>`,
+			NoScriptHTML: `<html><head><title>Shoe</title></head><body><div id="app"></div><a href="/">Home</a>` +
+				`<noscript><p>Enable JavaScript</p></noscript></body></html>`,
+		},
+	})
+	cfg := fakeConfig(renderer)
+
+	disabled := startServer(t, cfg)
+	if resp, body := get(t, disabled.URL+diffPath+"/product", nil); resp.StatusCode != http.StatusNotFound || len(renderer.Requests()) != 0 {
+		t.Fatalf("expected 404 without renders with the endpoint disabled, got %d: %s", resp.StatusCode, body)
+	}
+
+	cfg.DiffEndpoint = true
+	server := startServer(t, cfg)
+	resp, body := get(t, server.URL+diffPath+"/product", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var report diffReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if report.Rendered.Title != "Shoe" || report.Rendered.Description != "A shoe" || report.NoJS.Description != "" {
+		t.Fatalf("unexpected summaries: %+v, %+v", report.Rendered, report.NoJS)
+	}
+	if want := []string{"Red shoe"}; !slices.Equal(report.Rendered.Headings, want) || len(report.NoJS.Headings) != 0 {
+		t.Fatalf("unexpected headings: %v, %v", report.Rendered.Headings, report.NoJS.Headings)
+	}
+	if want := []string{"Red shoe", "In stock now", "Cart"}; !slices.Equal(report.OnlyRendered, want) {
+		t.Fatalf("expected only rendered %v, got %v", want, report.OnlyRendered)
+	}
+	if want := []string{"Enable JavaScript"}; !slices.Equal(report.OnlyNoJS, want) {
+		t.Fatalf("expected only no-js %v, got %v", want, report.OnlyNoJS)
+	}
+	if want := []string{"/cart"}; !slices.Equal(report.MissingLinks, want) {
+		t.Fatalf("expected missing links %v, got %v", want, report.MissingLinks)
+	}
+	// only "Home" of the rendered page's 7 words is present without JavaScript
+	if report.TextCoverage < 0.14 || report.TextCoverage > 0.15 {
+		t.Fatalf("expected text coverage 1/7, got %v", report.TextCoverage)
+	}
+
+	requests := renderer.Requests()
+	if len(requests) != 2 || requests[0].DisableJavaScript == requests[1].DisableJavaScript {
+		t.Fatalf("expected one render with and one without JavaScript, got %+v", requests)
+	}
+
+	resp, _ = get(t, server.URL+diffPath+"/product?"+formatParam+"=png", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for binary diff, got %d", resp.StatusCode)
+	}
+}
+
+func TestParseActionsWaitResponse(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{Routes: []Route{{Paths: []string{"/products/*"}, WaitResponse: &prerender.ResponseWait{URL: "/api/product/*"}}}}
+	r := httptest.NewRequest("GET", "/products/1", nil)
+	var options prerender.Options
+	if err := parseActions(r, cfg, &options); err != nil || options.WaitResponse == nil || options.WaitResponse.URL != "/api/product/*" {
+		t.Fatalf("expected route response wait, got %+v, %v", options.WaitResponse, err)
+	}
+
+	r.Header.Set(responseHeader, "/api/stock/* 200")
+	options = prerender.Options{}
+	if err := parseActions(r, cfg, &options); err != nil || options.WaitResponse == nil || *options.WaitResponse != (prerender.ResponseWait{URL: "/api/stock/*", Status: 200}) {
+		t.Fatalf("expected header response wait, got %+v, %v", options.WaitResponse, err)
+	}
+
+	r.Header.Set(responseHeader, "/api 600")
+	if err := parseActions(r, cfg, &prerender.Options{}); err == nil {
+		t.Fatal("expected error for invalid response wait header")
+	}
+}
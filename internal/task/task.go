@@ -5,6 +5,8 @@ import (
 	"errors"
 	"sync"
 	"time"
+
+	"github.com/IncorrectM/precrawl/internal/prerender"
 )
 
 var (
@@ -21,13 +23,34 @@ type Task struct {
 	Wait          time.Duration
 	WaitTimeout   time.Duration
 	QuerySelector string
-	ResultCh      chan Result
+	// Options holds per-request render options; nil renders HTML with defaults.
+	Options *prerender.Options
+	// Renderer names the renderer for the task; empty uses the server default.
+	Renderer string
+	// Enqueued is when the task was submitted, used to measure queue wait.
+	Enqueued time.Time
+	ResultCh chan Result
+}
+
+// Timing is the duration of one phase of a task, e.g. queue wait or navigation.
+type Timing struct {
+	Name     string
+	Duration time.Duration
 }
 
-// Result represents the outcome of executing a task.
+// Result represents the outcome of executing a task. Data and ContentType are
+// set for binary outputs such as screenshots. Diagnostics is set even when Err is.
 type Result struct {
-	HTML string
-	Err  error
+	HTML        string
+	Data        []byte
+	ContentType string
+	Diagnostics prerender.Diagnostics
+	HAR         *prerender.HAR
+	// Timings lists the phases of the task in order.
+	Timings []Timing
+	// Renderer names the renderer that produced the result.
+	Renderer string
+	Err      error
 }
 
 // TaskQueue stores tasks in FIFO order.
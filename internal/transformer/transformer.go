@@ -3,6 +3,7 @@ package transformer
 import (
 	"errors"
 	"log"
+	"time"
 )
 
 var ErrNilTransformer = errors.New("transformer is nil")
@@ -20,19 +21,34 @@ func DefaultTransformers() []Transformer {
 	}
 }
 
+// Timing is how long one transformer took.
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
 func ApplyAll(input string, transformers ...Transformer) (string, error) {
+	output, _, err := ApplyAllTimed(input, transformers...)
+	return output, err
+}
+
+// ApplyAllTimed is ApplyAll that also reports the duration of each transformer.
+func ApplyAllTimed(input string, transformers ...Transformer) (string, []Timing, error) {
 	output := input
+	timings := make([]Timing, 0, len(transformers))
 	for _, t := range transformers {
 		if t == nil {
-			return "", ErrNilTransformer
+			return "", timings, ErrNilTransformer
 		}
+		start := time.Now()
 		var err error
 		output, err = t.Transform(output)
+		timings = append(timings, Timing{Name: t.Name(), Duration: time.Since(start)})
 		if err != nil {
-			return "", err
+			return "", timings, err
 		}
 	}
-	return output, nil
+	return output, timings, nil
 }
 
 func FromNames(names ...string) []Transformer {
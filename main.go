@@ -8,11 +8,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/chromedp/chromedp"
+
 	"github.com/IncorrectM/precrawl/internal/browser"
 	"github.com/IncorrectM/precrawl/internal/config"
+	"github.com/IncorrectM/precrawl/internal/prerender"
 	"github.com/IncorrectM/precrawl/internal/server"
 	"github.com/IncorrectM/precrawl/internal/task"
 	"github.com/IncorrectM/precrawl/internal/transformer"
@@ -23,14 +27,6 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	// initialize task queue and browser pool
-	queue := task.NewQueue()
-	pool, err := browser.NewPool(ctx, 2)
-	if err != nil {
-		log.Fatalf("failed to create browser pool: %v", err)
-	}
-	defer pool.Close()
-
 	// read configuration from environment variables
 	baseTargetURL := os.Getenv("PRECRAWL_BASE_TARGET_URL")
 
@@ -54,6 +50,60 @@ func main() {
 	// by default, use 2 workers to process the queue
 	workerCount := 2
 
+	// by default, keep 2 pages open and grow up to one page per worker
+	poolMinSize := 2
+	poolMaxSize := 0
+	poolGrowAfter := browser.DefaultGrowAfter
+	poolIdleTimeout := browser.DefaultIdleTimeout
+
+	// by default, never restart the browser
+	poolRestartInterval := time.Duration(0)
+	poolMaxMemoryMB := 0
+
+	// by default, launch Chrome with chromedp's default options
+	var allocatorOpts []chromedp.ExecAllocatorOption
+	userDataDir := ""
+
+	// by default, do not limit page resources
+	var resourceLimits prerender.ResourceLimits
+
+	// by default, render with Chrome's viewport unless a device is requested
+	devices := prerender.DefaultDevices()
+	defaultDevice := ""
+	deviceAutoSelect := false
+
+	// by default, keep Chrome's locale, timezone and geolocation
+	defaultLocale := ""
+	defaultTimezone := ""
+	var defaultGeolocation *prerender.Geolocation
+
+	// by default, navigate without extra headers or cookies
+	var hosts map[string]server.TargetExtras
+	var headerAllowlist, cookieAllowlist []string
+
+	// by default, forward no client headers to the target
+	var forwardHeaders []string
+
+	// by default, inject no scripts before navigation
+	var scripts []server.Script
+
+	// by default, run no actions and reject them in requests
+	var routes []server.Route
+	requestActions := false
+
+	// by default, do not write HAR files
+	harDir := ""
+	harThreshold := time.Duration(0)
+	harEndpoint := false
+	diffEndpoint := false
+
+	// by default, serialize the light DOM only and leave iframes empty
+	shadowDOM := false
+	var inlineFrames *prerender.FrameInlining
+
+	// by default, render every page in the browser
+	renderer := prerender.RendererChrome
+
 	// read configuration from config.yml
 	// this overrides environment variables if present
 	configData, err := os.ReadFile("config.yml")
@@ -62,7 +112,7 @@ func main() {
 	} else {
 		config, err := config.LoadConfig(configData)
 		if err != nil {
-			log.Printf("warning: failed to parse config.yml: %v", err)
+			log.Fatalf("failed to parse config.yml: %v", err)
 		}
 		if config.BaseTargetURL != nil {
 			baseTargetURL = *config.BaseTargetURL
@@ -83,6 +133,124 @@ func main() {
 		if config.WorkerCount != nil && *config.WorkerCount > 0 {
 			workerCount = *config.WorkerCount
 		}
+		if config.PoolMinSize != nil {
+			poolMinSize = *config.PoolMinSize
+		}
+		if config.PoolMaxSize != nil {
+			poolMaxSize = *config.PoolMaxSize
+		}
+		if config.PoolGrowAfter != nil {
+			parsed, err := time.ParseDuration(*config.PoolGrowAfter)
+			if err != nil {
+				log.Fatalf("invalid pool_grow_after in config.yml: %v", err)
+			}
+			poolGrowAfter = parsed
+		}
+		if config.PoolIdleTimeout != nil {
+			parsed, err := time.ParseDuration(*config.PoolIdleTimeout)
+			if err != nil {
+				log.Fatalf("invalid pool_idle_timeout in config.yml: %v", err)
+			}
+			poolIdleTimeout = parsed
+		}
+		if config.PoolRestartInterval != nil {
+			parsed, err := time.ParseDuration(*config.PoolRestartInterval)
+			if err != nil {
+				log.Fatalf("invalid pool_restart_interval in config.yml: %v", err)
+			}
+			poolRestartInterval = parsed
+		}
+		if config.PoolMaxMemoryMB != nil {
+			poolMaxMemoryMB = *config.PoolMaxMemoryMB
+		}
+		if config.Browser != nil {
+			allocatorOpts = config.Browser.AllocatorOptions()
+			if config.Browser.UserDataDir != nil {
+				userDataDir = *config.Browser.UserDataDir
+			}
+		}
+		if config.ResourceLimits != nil {
+			// already validated by LoadConfig
+			resourceLimits, _ = config.ResourceLimits.Limits()
+		}
+		// already validated by LoadConfig
+		devices, _ = config.DeviceProfiles()
+		if config.DefaultDevice != nil {
+			defaultDevice = strings.ToLower(*config.DefaultDevice)
+		}
+		if config.DeviceAutoSelect != nil {
+			deviceAutoSelect = *config.DeviceAutoSelect
+		}
+		if config.DefaultLocale != nil {
+			defaultLocale = *config.DefaultLocale
+		}
+		if config.DefaultTimezone != nil {
+			defaultTimezone = *config.DefaultTimezone
+		}
+		defaultGeolocation = config.DefaultGeolocation.Geolocation()
+		if config.Hosts != nil {
+			hosts = make(map[string]server.TargetExtras, len(*config.Hosts))
+			for host, h := range *config.Hosts {
+				options := h.Options()
+				hosts[strings.ToLower(host)] = server.TargetExtras{Headers: options.Headers, Cookies: options.Cookies}
+			}
+		}
+		if config.ExtraHeaderAllow != nil {
+			headerAllowlist = *config.ExtraHeaderAllow
+		}
+		if config.ExtraCookieAllow != nil {
+			cookieAllowlist = *config.ExtraCookieAllow
+		}
+		if config.ForwardHeaders != nil {
+			forwardHeaders = *config.ForwardHeaders
+		}
+		if config.Scripts != nil {
+			for _, script := range *config.Scripts {
+				source, err := script.Source()
+				if err != nil {
+					log.Fatalf("invalid scripts in config.yml: %v", err)
+				}
+				scripts = append(scripts, server.Script{Source: source, Paths: script.Paths})
+			}
+		}
+		if config.Routes != nil {
+			for _, route := range *config.Routes {
+				// already validated by LoadConfig
+				scroll, _ := route.PrerenderAutoScroll()
+				routeRenderer := ""
+				if route.Renderer != "" {
+					routeRenderer, _ = prerender.ParseRenderer(route.Renderer)
+				}
+				wait, _ := route.PrerenderResponseWait()
+				routes = append(routes, server.Route{Paths: route.Paths, AutoScroll: scroll, Actions: route.PrerenderActions(), Renderer: routeRenderer, WaitResponse: wait})
+			}
+		}
+		if config.RequestActions != nil {
+			requestActions = *config.RequestActions
+		}
+		if config.ShadowDOM != nil {
+			shadowDOM = *config.ShadowDOM
+		}
+		inlineFrames = config.InlineFrames.FrameInlining()
+		if config.Renderer != nil {
+			// already validated by LoadConfig
+			renderer, _ = prerender.ParseRenderer(*config.Renderer)
+		}
+		if config.HARDir != nil {
+			harDir = *config.HARDir
+		}
+		if config.HAREndpoint != nil {
+			harEndpoint = *config.HAREndpoint
+		}
+		if config.DiffEndpoint != nil {
+			diffEndpoint = *config.DiffEndpoint
+		}
+		if config.HARThreshold != nil {
+			harThreshold, err = time.ParseDuration(*config.HARThreshold)
+			if err != nil {
+				log.Fatalf("invalid har_threshold in config.yml: %v", err)
+			}
+		}
 	}
 
 	// read configuration from command-line flags
@@ -90,6 +258,20 @@ func main() {
 	baseTargetURLFlag := flag.String("base-url", baseTargetURL, "base target URL for rendering")
 	defaultSelectorFlag := flag.String("default-selector", defaultSelector, "default CSS selector to wait for during rendering")
 	defaultWaitTimeoutFlag := flag.Duration("default-wait-timeout", defaultWaitTimeout, "default wait timeout for rendering (e.g. 5s, 500ms)")
+	poolMinSizeFlag := flag.Int("pool-min-size", poolMinSize, "number of browser pages kept open when idle")
+	poolMaxSizeFlag := flag.Int("pool-max-size", poolMaxSize, "maximum number of browser pages (0 means one per worker)")
+	poolGrowAfterFlag := flag.Duration("pool-grow-after", poolGrowAfter, "how long a worker waits for a free page before the pool grows")
+	poolIdleTimeoutFlag := flag.Duration("pool-idle-timeout", poolIdleTimeout, "how long an extra page may stay idle before it is closed")
+	poolRestartIntervalFlag := flag.Duration("pool-restart-interval", poolRestartInterval, "rolling browser restart interval (0 disables)")
+	defaultDeviceFlag := flag.String("default-device", defaultDevice, "device profile used when a request does not select one (e.g. mobile)")
+	defaultLocaleFlag := flag.String("default-locale", defaultLocale, "locale emulated when a request does not set one (e.g. de-DE)")
+	defaultTimezoneFlag := flag.String("default-timezone", defaultTimezone, "timezone ID emulated when a request does not set one (e.g. Europe/Berlin)")
+	harDirFlag := flag.String("har-dir", harDir, "directory receiving HAR files of slow renders (empty disables)")
+	harThresholdFlag := flag.Duration("har-threshold", harThreshold, "minimum render duration for writing a HAR file")
+	harEndpointFlag := flag.Bool("har-endpoint", harEndpoint, "serve HARs of renders at /_precrawl/har/")
+	diffEndpointFlag := flag.Bool("diff-endpoint", diffEndpoint, "serve JavaScript diff reports at /_precrawl/diff/")
+	rendererFlag := flag.String("renderer", renderer, "default renderer: chrome, http or auto")
+	poolMaxMemoryMBFlag := flag.Int("pool-max-memory-mb", poolMaxMemoryMB, "browser memory in MiB that triggers a rolling restart (0 disables)")
 
 	flag.Parse()
 
@@ -98,14 +280,58 @@ func main() {
 		log.Fatal("PRECRAWL_BASE_TARGET_URL is required")
 	}
 
+	// initialize task queue and browser pool
+	queue := task.NewQueue()
+	poolConfig := browser.PoolConfig{
+		MinSize:     *poolMinSizeFlag,
+		MaxSize:     *poolMaxSizeFlag,
+		GrowAfter:   *poolGrowAfterFlag,
+		Backlog:     queue.Len,
+		IdleTimeout: *poolIdleTimeoutFlag,
+
+		RestartInterval:  *poolRestartIntervalFlag,
+		MaxBrowserMemory: uint64(max(*poolMaxMemoryMBFlag, 0)) << 20,
+		UserDataDir:      userDataDir,
+	}
+	if poolConfig.MaxSize <= 0 {
+		poolConfig.MaxSize = max(*workerCountFlag, poolConfig.MinSize)
+	}
+
+	pool, err := browser.NewElasticPool(ctx, poolConfig, allocatorOpts...)
+	if err != nil {
+		log.Fatalf("failed to create browser pool: %v", err)
+	}
+	defer pool.Close()
+
 	// start the server
 	if err := server.Run(ctx, server.Config{
-		Queue:              queue,
-		Pool:               pool,
-		WorkerCount:        *workerCountFlag,
-		BaseTargetURL:      *baseTargetURLFlag,
-		DefaultSelector:    *defaultSelectorFlag,
-		DefaultWaitTimeout: *defaultWaitTimeoutFlag,
+		Queue:               queue,
+		Pool:                pool,
+		WorkerCount:         *workerCountFlag,
+		BaseTargetURL:       *baseTargetURLFlag,
+		DefaultSelector:     *defaultSelectorFlag,
+		DefaultWaitTimeout:  *defaultWaitTimeoutFlag,
+		ResourceLimits:      resourceLimits,
+		Devices:             devices,
+		DefaultDevice:       strings.ToLower(*defaultDeviceFlag),
+		AutoSelectDevice:    deviceAutoSelect,
+		DefaultLocale:       *defaultLocaleFlag,
+		DefaultTimezone:     *defaultTimezoneFlag,
+		DefaultGeolocation:  defaultGeolocation,
+		Hosts:               hosts,
+		HeaderAllowlist:     headerAllowlist,
+		CookieAllowlist:     cookieAllowlist,
+		ForwardHeaders:      forwardHeaders,
+		Scripts:             scripts,
+		Routes:              routes,
+		AllowRequestActions: requestActions,
+		HARDir:              *harDirFlag,
+		HARThreshold:        *harThresholdFlag,
+		HAREndpoint:         *harEndpointFlag,
+		DiffEndpoint:        *diffEndpointFlag,
+		ShadowDOM:           shadowDOM,
+		InlineFrames:        inlineFrames,
+		DefaultRenderer:     strings.ToLower(*rendererFlag),
 	}, transformers); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("server error: %v", err)
 	}